@@ -0,0 +1,206 @@
+package async
+
+import (
+	"context"
+	"errors"
+	"github.com/jace996/uow/event"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQueueFull = errors.New("async: queue full")
+	ErrClosed    = errors.New("async: producer closed")
+)
+
+// Backpressure decides what Send does when the queue is full
+type Backpressure int
+
+const (
+	// Block waits until the queue has room or ctx is done
+	Block Backpressure = iota
+	// Drop discards the event and reports it with ErrQueueFull
+	Drop
+	// Error returns ErrQueueFull
+	Error
+)
+
+// DeliveryReport is called with every batch sent to the wrapped producer, and with dropped events
+type DeliveryReport func(events []event.Event, err error)
+
+type options struct {
+	queueSize    int
+	linger       time.Duration
+	maxBatch     int
+	backpressure Backpressure
+	report       DeliveryReport
+}
+
+type Option func(*options)
+
+// WithQueueSize change the max number of queued events. default 1000
+func WithQueueSize(n int) Option {
+	return func(o *options) {
+		o.queueSize = n
+	}
+}
+
+// WithLinger change how long to wait for more events before sending a batch. default 10ms
+func WithLinger(d time.Duration) Option {
+	return func(o *options) {
+		o.linger = d
+	}
+}
+
+// WithMaxBatch change the max number of events in one BatchSend. default 100
+func WithMaxBatch(n int) Option {
+	return func(o *options) {
+		o.maxBatch = n
+	}
+}
+
+// WithBackpressure change the behavior when the queue is full. default Block
+func WithBackpressure(b Backpressure) Option {
+	return func(o *options) {
+		o.backpressure = b
+	}
+}
+
+// WithDeliveryReport receive delivery results
+func WithDeliveryReport(r DeliveryReport) Option {
+	return func(o *options) {
+		o.report = r
+	}
+}
+
+// Producer queues events and sends them to the wrapped producer in batches in background.
+// Send returns once the event is queued, results are reported by DeliveryReport. Close flushes queued events
+type Producer struct {
+	wrap  event.Producer
+	opt   *options
+	queue chan event.Event
+	// mtx guards closed, senders hold read lock while queueing
+	mtx    sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+var _ event.Producer = (*Producer)(nil)
+
+func New(wrap event.Producer, opts ...Option) *Producer {
+	opt := &options{
+		queueSize: 1000,
+		linger:    10 * time.Millisecond,
+		maxBatch:  100,
+		report:    func(events []event.Event, err error) {},
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	p := &Producer{
+		wrap:  wrap,
+		opt:   opt,
+		queue: make(chan event.Event, opt.queueSize),
+		done:  make(chan struct{}),
+	}
+	go p.loop()
+	return p
+}
+
+func (p *Producer) Send(ctx context.Context, msg event.Event) error {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	if p.closed {
+		return ErrClosed
+	}
+	switch p.opt.backpressure {
+	case Drop:
+		select {
+		case p.queue <- msg:
+		default:
+			p.opt.report([]event.Event{msg}, ErrQueueFull)
+		}
+		return nil
+	case Error:
+		select {
+		case p.queue <- msg:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	default:
+		select {
+		case p.queue <- msg:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// BatchSend queues events in order. Stops at the first event which can not be queued.
+// If some events are queued, returns an *event.BatchError with errors of the events not queued, so they can be resent alone
+func (p *Producer) BatchSend(ctx context.Context, msg []event.Event) error {
+	for i, e := range msg {
+		err := p.Send(ctx, e)
+		if err == nil {
+			continue
+		}
+		if i == 0 {
+			return err
+		}
+		errs := make([]error, len(msg))
+		for j := i; j < len(msg); j++ {
+			errs[j] = err
+		}
+		return event.NewBatchError(errs)
+	}
+	return nil
+}
+
+// Close stops accepting events, flushes queued ones and closes the wrapped producer
+func (p *Producer) Close() error {
+	p.mtx.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mtx.Unlock()
+	<-p.done
+	return p.wrap.Close()
+}
+
+func (p *Producer) loop() {
+	defer close(p.done)
+	var batch []event.Event
+	timer := time.NewTimer(p.opt.linger)
+	timer.Stop()
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := p.wrap.BatchSend(context.Background(), batch)
+		p.opt.report(batch, err)
+		batch = nil
+	}
+	for {
+		select {
+		case e, ok := <-p.queue:
+			if !ok {
+				timer.Stop()
+				flush()
+				return
+			}
+			if len(batch) == 0 {
+				timer.Reset(p.opt.linger)
+			}
+			batch = append(batch, e)
+			if len(batch) >= p.opt.maxBatch {
+				timer.Stop()
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package async
+
+import (
+	"context"
+	"errors"
+	"github.com/jace996/uow/event"
+	"github.com/jace996/uow/event/memory"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	mtx     sync.Mutex
+	batches []int
+	errs    []error
+}
+
+func (r *recorder) report(events []event.Event, err error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.batches = append(r.batches, len(events))
+	r.errs = append(r.errs, err)
+}
+
+func TestBatch(t *testing.T) {
+	b := memory.NewBroker()
+	r := &recorder{}
+	p := New(b, WithMaxBatch(3), WithLinger(time.Hour), WithDeliveryReport(r.report))
+	for i := 0; i < 7; i++ {
+		assert.NoError(t, p.Send(context.Background(), event.NewMessage("order", nil)))
+	}
+	//flush on close
+	assert.NoError(t, p.Close())
+	assert.Equal(t, []int{3, 3, 1}, r.batches)
+	assert.Equal(t, []error{nil, nil, nil}, r.errs)
+	assert.ErrorIs(t, p.Send(context.Background(), event.NewMessage("order", nil)), ErrClosed)
+	//wrapped producer is closed
+	assert.ErrorIs(t, b.Send(context.Background(), event.NewMessage("order", nil)), memory.ErrClosed)
+}
+
+func TestLinger(t *testing.T) {
+	b := memory.NewBroker()
+	p := New(b, WithLinger(time.Millisecond))
+	defer p.Close()
+	assert.NoError(t, p.Send(context.Background(), event.NewMessage("order", nil)))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := b.WaitFor(ctx, "order")
+	assert.NoError(t, err)
+}
+
+// blockProducer blocks BatchSend until release is closed
+type blockProducer struct {
+	// entered is signaled when BatchSend is called
+	entered chan struct{}
+	release chan struct{}
+	err     error
+}
+
+func newBlockProducer(err error) *blockProducer {
+	return &blockProducer{entered: make(chan struct{}, 1), release: make(chan struct{}), err: err}
+}
+
+func (p *blockProducer) Close() error {
+	return nil
+}
+
+func (p *blockProducer) Send(ctx context.Context, msg event.Event) error {
+	return p.BatchSend(ctx, []event.Event{msg})
+}
+
+func (p *blockProducer) BatchSend(ctx context.Context, msg []event.Event) error {
+	select {
+	case p.entered <- struct{}{}:
+	default:
+	}
+	<-p.release
+	return p.err
+}
+
+func TestBackpressure(t *testing.T) {
+	fill := func(p *Producer, bp *blockProducer) {
+		//the first event is taken by the loop, the second fills the queue
+		assert.NoError(t, p.Send(context.Background(), event.NewMessage("1", nil)))
+		<-bp.entered
+		assert.NoError(t, p.Send(context.Background(), event.NewMessage("2", nil)))
+	}
+
+	t.Run("error", func(t *testing.T) {
+		bp := newBlockProducer(nil)
+		p := New(bp, WithQueueSize(1), WithMaxBatch(1), WithBackpressure(Error))
+		fill(p, bp)
+		assert.ErrorIs(t, p.Send(context.Background(), event.NewMessage("3", nil)), ErrQueueFull)
+		close(bp.release)
+		assert.NoError(t, p.Close())
+	})
+
+	t.Run("partial batch", func(t *testing.T) {
+		bp := newBlockProducer(nil)
+		p := New(bp, WithQueueSize(2), WithMaxBatch(1), WithBackpressure(Error))
+		assert.NoError(t, p.Send(context.Background(), event.NewMessage("1", nil)))
+		<-bp.entered
+		err := p.BatchSend(context.Background(), []event.Event{event.NewMessage("2", nil), event.NewMessage("3", nil), event.NewMessage("4", nil)})
+		var be *event.BatchError
+		assert.ErrorAs(t, err, &be)
+		//only the event not queued should be resent
+		assert.Equal(t, []int{2}, be.Failed())
+		assert.ErrorIs(t, be.Errs[2], ErrQueueFull)
+		close(bp.release)
+		assert.NoError(t, p.Close())
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		bp := newBlockProducer(nil)
+		r := &recorder{}
+		p := New(bp, WithQueueSize(1), WithMaxBatch(1), WithBackpressure(Drop), WithDeliveryReport(r.report))
+		fill(p, bp)
+		assert.NoError(t, p.Send(context.Background(), event.NewMessage("3", nil)))
+		close(bp.release)
+		assert.NoError(t, p.Close())
+		assert.Equal(t, []int{1, 1, 1}, r.batches)
+		assert.ErrorIs(t, r.errs[0], ErrQueueFull)
+	})
+
+	t.Run("block", func(t *testing.T) {
+		sendErr := errors.New("broker down")
+		bp := newBlockProducer(sendErr)
+		r := &recorder{}
+		p := New(bp, WithQueueSize(1), WithMaxBatch(1), WithDeliveryReport(r.report))
+		fill(p, bp)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, p.Send(ctx, event.NewMessage("3", nil)), context.DeadlineExceeded)
+		close(bp.release)
+		assert.NoError(t, p.Close())
+		assert.Equal(t, []error{sendErr, sendErr}, r.errs)
+	})
+}
+
+func TestTransactionalProducerWrap(t *testing.T) {
+	b := memory.NewBroker()
+	transP := event.NewTransactionalProducer(New(b), nil)
+	assert.NoError(t, transP.Send(context.Background(), event.NewMessage("order", nil)))
+	assert.NoError(t, transP.Close())
+	assert.Equal(t, []string{"order"}, b.PublishedKeys())
+}
@@ -0,0 +1,97 @@
+package event
+
+import (
+	"errors"
+	"fmt"
+)
+
+// BatchError reports the result of every event of a BatchSend. Errs[i] is the error of the i-th event, nil means delivered
+type BatchError struct {
+	Errs []error
+}
+
+func NewBatchError(errs []error) *BatchError {
+	return &BatchError{Errs: errs}
+}
+
+func (b *BatchError) Error() string {
+	failed := b.Failed()
+	if len(failed) == 0 {
+		return fmt.Sprintf("event: 0 of %d events failed", len(b.Errs))
+	}
+	return fmt.Sprintf("event: %d of %d events failed: %s", len(failed), len(b.Errs), b.Errs[failed[0]].Error())
+}
+
+// Unwrap returns non nil errors, so errors.Is and errors.As inspect every failure
+func (b *BatchError) Unwrap() []error {
+	var ret []error
+	for _, err := range b.Errs {
+		if err != nil {
+			ret = append(ret, err)
+		}
+	}
+	return ret
+}
+
+// Failed returns indexes of failed events
+func (b *BatchError) Failed() []int {
+	var ret []int
+	for i, err := range b.Errs {
+		if err != nil {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+// FailedEvents returns events of msg failed by err. If err is a BatchError matching msg only failed ones are returned,
+// otherwise all events are considered failed. nil err returns nil
+func FailedEvents(msg []Event, err error) []Event {
+	if err == nil {
+		return nil
+	}
+	var be *BatchError
+	if !errors.As(err, &be) || len(be.Errs) != len(msg) {
+		return msg
+	}
+	var ret []Event
+	for _, i := range be.Failed() {
+		ret = append(ret, msg[i])
+	}
+	return ret
+}
+
+// Size returns the approximate size in bytes of e
+func Size(e Event) int {
+	n := len(e.Key()) + len(e.Value())
+	if h := e.Header(); h != nil {
+		for _, k := range h.Keys() {
+			n += len(k) + len(h.Get(k))
+		}
+	}
+	return n
+}
+
+// Split events into chunks of at most maxSize events and maxBytes bytes. 0 means no limit.
+// An event larger than maxBytes is put into its own chunk
+func Split(events []Event, maxSize, maxBytes int) [][]Event {
+	if len(events) == 0 {
+		return nil
+	}
+	if maxSize <= 0 && maxBytes <= 0 {
+		return [][]Event{events}
+	}
+	var ret [][]Event
+	var chunk []Event
+	bytes := 0
+	for _, e := range events {
+		size := Size(e)
+		if len(chunk) > 0 && ((maxSize > 0 && len(chunk) >= maxSize) || (maxBytes > 0 && bytes+size > maxBytes)) {
+			ret = append(ret, chunk)
+			chunk, bytes = nil, 0
+		}
+		chunk = append(chunk, e)
+		bytes += size
+	}
+	return append(ret, chunk)
+}
@@ -0,0 +1,96 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// partialProducer fails each key the given times
+type partialProducer struct {
+	fail    map[string]int
+	batches [][]string
+	sent    []string
+}
+
+func (p *partialProducer) Close() error {
+	return nil
+}
+
+func (p *partialProducer) Send(ctx context.Context, msg Event) error {
+	return p.BatchSend(ctx, []Event{msg})
+}
+
+func (p *partialProducer) BatchSend(ctx context.Context, msg []Event) error {
+	errs := make([]error, len(msg))
+	var batch []string
+	for i, e := range msg {
+		batch = append(batch, e.Key())
+		if p.fail[e.Key()] > 0 {
+			p.fail[e.Key()]--
+			errs[i] = errors.New("fail " + e.Key())
+			continue
+		}
+		p.sent = append(p.sent, e.Key())
+	}
+	p.batches = append(p.batches, batch)
+	be := NewBatchError(errs)
+	if len(be.Failed()) == 0 {
+		return nil
+	}
+	return be
+}
+
+func TestBatchError(t *testing.T) {
+	target := errors.New("target")
+	be := NewBatchError([]error{nil, target, nil})
+	assert.Equal(t, []int{1}, be.Failed())
+	assert.ErrorIs(t, be, target)
+	assert.Contains(t, be.Error(), "1 of 3")
+
+	msg := []Event{NewMessage("1", nil), NewMessage("2", nil), NewMessage("3", nil)}
+	assert.Equal(t, []Event{msg[1]}, FailedEvents(msg, be))
+	assert.Equal(t, msg, FailedEvents(msg, target))
+	assert.Nil(t, FailedEvents(msg, nil))
+}
+
+func TestSplit(t *testing.T) {
+	//fixed headers, so events with the same value have the same size
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	msg := []Event{
+		NewBuilder("1").Id("1").Time(now).Value(make([]byte, 10)).Build(),
+		NewBuilder("2").Id("2").Time(now).Value(make([]byte, 10)).Build(),
+		NewBuilder("3").Id("3").Time(now).Value(make([]byte, 100)).Build(),
+		NewBuilder("4").Id("4").Time(now).Value(make([]byte, 10)).Build(),
+	}
+	assert.Len(t, Split(msg, 0, 0), 1)
+	assert.Len(t, Split(msg, 3, 0), 2)
+	chunks := Split(msg, 0, Size(msg[0])*2)
+	assert.Equal(t, [][]Event{{msg[0], msg[1]}, {msg[2]}, {msg[3]}}, chunks)
+	assert.Nil(t, Split(nil, 1, 1))
+}
+
+func TestPartialResend(t *testing.T) {
+	p := &partialProducer{fail: map[string]int{"2": 1, "4": 5}}
+	var lost []string
+	mgr := newRecordManager(p, WithMaxBatch(2, 0), WithRetry(2, nil), WithLostHandler(func(ctx context.Context, events []Event, err error) {
+		for _, e := range events {
+			lost = append(lost, e.Key())
+		}
+	}))
+	transP := NewTransactionalProducer(p, []string{"event"})
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		for _, k := range []string{"1", "2", "3", "4", "5"} {
+			if err := transP.Send(ctx, NewMessage(k, nil)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, [][]string{{"1", "2"}, {"3", "4"}, {"5"}, {"2", "4"}, {"4"}}, p.batches)
+	assert.Equal(t, []string{"1", "3", "5", "2"}, p.sent)
+	assert.Equal(t, []string{"4"}, lost)
+}
@@ -0,0 +1,388 @@
+package cloudevents
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jace996/uow"
+	"github.com/jace996/uow/event"
+	"strings"
+	"time"
+)
+
+const (
+	SpecVersion = "1.0"
+	// ContentTypeStructured is the content type of structured mode events
+	ContentTypeStructured = "application/cloudevents+json"
+	// HeaderPrefix is the header prefix of attributes in binary mode
+	HeaderPrefix = "ce-"
+	// ExtensionUowId is the extension carrying the id of the unit of work which produced the event
+	ExtensionUowId = "uowid"
+)
+
+var (
+	ErrInvalid = errors.New("cloudevents: invalid event")
+)
+
+// Mode is the content mode of CloudEvents
+type Mode int
+
+const (
+	// Binary mode keeps event value as data and carries attributes in "ce-" headers
+	Binary Mode = iota
+	// Structured mode encodes attributes and data into a JSON envelope
+	Structured
+)
+
+// Attributes of a CloudEvent
+type Attributes struct {
+	Id              string
+	Source          string
+	SpecVersion     string
+	Type            string
+	Subject         string
+	Time            time.Time
+	DataContentType string
+	DataSchema      string
+	Extensions      map[string]string
+}
+
+var reserved = map[string]bool{
+	"id": true, "source": true, "specversion": true, "type": true, "subject": true,
+	"time": true, "datacontenttype": true, "dataschema": true, "data": true, "data_base64": true,
+}
+
+// Validate checks required attributes and extension names
+func (a Attributes) Validate() error {
+	if len(a.Id) == 0 || len(a.Source) == 0 || len(a.Type) == 0 || len(a.SpecVersion) == 0 {
+		return fmt.Errorf("%w: id, source, type and specversion are required", ErrInvalid)
+	}
+	for k := range a.Extensions {
+		if reserved[k] || !validExtension(k) {
+			return fmt.Errorf("%w: invalid extension name %q", ErrInvalid, k)
+		}
+	}
+	return nil
+}
+
+func validExtension(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Encode e with attrs into mode. Headers of e except "ce-" ones are kept
+func Encode(e event.Event, attrs Attributes, mode Mode) (*event.Message, error) {
+	if len(attrs.SpecVersion) == 0 {
+		attrs.SpecVersion = SpecVersion
+	}
+	if err := attrs.Validate(); err != nil {
+		return nil, err
+	}
+	h := event.CloneHeader(e.Header())
+	for _, k := range h.Keys() {
+		if strings.HasPrefix(k, HeaderPrefix) {
+			h.Del(k)
+		}
+	}
+	if mode == Structured {
+		value, err := encodeStructured(e.Value(), attrs)
+		if err != nil {
+			return nil, err
+		}
+		h.Set(event.HeaderContentType, ContentTypeStructured)
+		return event.NewBuilder(e.Key()).Value(value).Headers(h).Build(), nil
+	}
+	set := func(k, v string) {
+		if len(v) > 0 {
+			h.Set(HeaderPrefix+k, v)
+		}
+	}
+	set("id", attrs.Id)
+	set("source", attrs.Source)
+	set("specversion", attrs.SpecVersion)
+	set("type", attrs.Type)
+	set("subject", attrs.Subject)
+	set("dataschema", attrs.DataSchema)
+	if !attrs.Time.IsZero() {
+		set("time", attrs.Time.UTC().Format(time.RFC3339Nano))
+	}
+	if len(attrs.DataContentType) > 0 {
+		h.Set(event.HeaderContentType, attrs.DataContentType)
+	}
+	for k, v := range attrs.Extensions {
+		set(k, v)
+	}
+	return event.NewBuilder(e.Key()).Value(e.Value()).Headers(h).Build(), nil
+}
+
+func isJSON(contentType string) bool {
+	if len(contentType) == 0 {
+		return true
+	}
+	ct := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	return ct == "application/json" || ct == "text/json" || strings.HasSuffix(ct, "+json")
+}
+
+func encodeStructured(data []byte, attrs Attributes) ([]byte, error) {
+	env := map[string]interface{}{}
+	for k, v := range attrs.Extensions {
+		env[k] = v
+	}
+	env["id"] = attrs.Id
+	env["source"] = attrs.Source
+	env["specversion"] = attrs.SpecVersion
+	env["type"] = attrs.Type
+	if len(attrs.Subject) > 0 {
+		env["subject"] = attrs.Subject
+	}
+	if len(attrs.DataSchema) > 0 {
+		env["dataschema"] = attrs.DataSchema
+	}
+	if len(attrs.DataContentType) > 0 {
+		env["datacontenttype"] = attrs.DataContentType
+	}
+	if !attrs.Time.IsZero() {
+		env["time"] = attrs.Time.UTC().Format(time.RFC3339Nano)
+	}
+	if data != nil {
+		if isJSON(attrs.DataContentType) && json.Valid(data) {
+			env["data"] = json.RawMessage(data)
+		} else {
+			env["data_base64"] = base64.StdEncoding.EncodeToString(data)
+		}
+	}
+	return json.Marshal(env)
+}
+
+// Decode reads attributes of e in either mode, and returns a Message whose value is the event data.
+// "ce-" headers are removed from the returned Message
+func Decode(e event.Event) (Attributes, *event.Message, error) {
+	h := event.CloneHeader(e.Header())
+	var attrs Attributes
+	var data []byte
+	if strings.HasPrefix(strings.ToLower(h.Get(event.HeaderContentType)), ContentTypeStructured) {
+		var err error
+		attrs, data, err = decodeStructured(e.Value())
+		if err != nil {
+			return attrs, nil, err
+		}
+		h.Del(event.HeaderContentType)
+	} else {
+		attrs = decodeBinary(h)
+		data = e.Value()
+	}
+	for _, k := range h.Keys() {
+		if strings.HasPrefix(k, HeaderPrefix) {
+			h.Del(k)
+		}
+	}
+	if len(attrs.DataContentType) > 0 {
+		h.Set(event.HeaderContentType, attrs.DataContentType)
+	}
+	if err := attrs.Validate(); err != nil {
+		return attrs, nil, err
+	}
+	return attrs, event.NewBuilder(e.Key()).Value(data).Headers(h).Build(), nil
+}
+
+func decodeBinary(h event.MapHeader) Attributes {
+	attrs := Attributes{
+		DataContentType: h.Get(event.HeaderContentType),
+		Extensions:      map[string]string{},
+	}
+	for _, k := range h.Keys() {
+		if !strings.HasPrefix(k, HeaderPrefix) {
+			continue
+		}
+		v := h.Get(k)
+		switch name := strings.TrimPrefix(k, HeaderPrefix); name {
+		case "id":
+			attrs.Id = v
+		case "source":
+			attrs.Source = v
+		case "specversion":
+			attrs.SpecVersion = v
+		case "type":
+			attrs.Type = v
+		case "subject":
+			attrs.Subject = v
+		case "dataschema":
+			attrs.DataSchema = v
+		case "time":
+			attrs.Time, _ = time.Parse(time.RFC3339Nano, v)
+		default:
+			attrs.Extensions[name] = v
+		}
+	}
+	return attrs
+}
+
+func decodeStructured(value []byte) (Attributes, []byte, error) {
+	attrs := Attributes{Extensions: map[string]string{}}
+	var env map[string]json.RawMessage
+	if err := json.Unmarshal(value, &env); err != nil {
+		return attrs, nil, fmt.Errorf("%w: %s", ErrInvalid, err.Error())
+	}
+	var data []byte
+	for k, raw := range env {
+		if k == "data" {
+			data = raw
+			continue
+		}
+		var v string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			//extensions may be numbers or booleans
+			v = string(raw)
+		}
+		switch k {
+		case "id":
+			attrs.Id = v
+		case "source":
+			attrs.Source = v
+		case "specversion":
+			attrs.SpecVersion = v
+		case "type":
+			attrs.Type = v
+		case "subject":
+			attrs.Subject = v
+		case "dataschema":
+			attrs.DataSchema = v
+		case "datacontenttype":
+			attrs.DataContentType = v
+		case "time":
+			attrs.Time, _ = time.Parse(time.RFC3339Nano, v)
+		case "data_base64":
+			b, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return attrs, nil, fmt.Errorf("%w: %s", ErrInvalid, err.Error())
+			}
+			data = b
+		default:
+			attrs.Extensions[k] = v
+		}
+	}
+	if raw, ok := env["data"]; ok && !isJSON(attrs.DataContentType) {
+		//non json data carried as json string
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			data = []byte(s)
+		}
+	}
+	return attrs, data, nil
+}
+
+type options struct {
+	mode    Mode
+	typ     func(e event.Event) string
+	subject func(e event.Event) string
+}
+
+type Option func(*options)
+
+// WithMode change the content mode. default Binary
+func WithMode(mode Mode) Option {
+	return func(o *options) {
+		o.mode = mode
+	}
+}
+
+// WithType change how to resolve the event type. default is the event.HeaderType header or the event key
+func WithType(f func(e event.Event) string) Option {
+	return func(o *options) {
+		o.typ = f
+	}
+}
+
+// WithSubject change how to resolve the subject. default is the event key
+func WithSubject(f func(e event.Event) string) Option {
+	return func(o *options) {
+		o.subject = f
+	}
+}
+
+// Producer wraps an event.Producer and sends events as CloudEvents.
+// Id, source, type, time and subject are filled automatically, and the current unit of work id is added as ExtensionUowId
+type Producer struct {
+	wrap   event.Producer
+	source string
+	opt    *options
+}
+
+var _ event.Producer = (*Producer)(nil)
+
+func NewProducer(wrap event.Producer, source string, opts ...Option) *Producer {
+	opt := &options{
+		mode: Binary,
+		typ: func(e event.Event) string {
+			if t := event.CloneHeader(e.Header()).Get(event.HeaderType); len(t) > 0 {
+				return t
+			}
+			return e.Key()
+		},
+		subject: func(e event.Event) string {
+			return e.Key()
+		},
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	return &Producer{wrap: wrap, source: source, opt: opt}
+}
+
+func (p *Producer) Close() error {
+	return p.wrap.Close()
+}
+
+func (p *Producer) Send(ctx context.Context, msg event.Event) error {
+	e, err := p.encode(ctx, msg)
+	if err != nil {
+		return err
+	}
+	return p.wrap.Send(ctx, e)
+}
+
+func (p *Producer) BatchSend(ctx context.Context, msg []event.Event) error {
+	events := make([]event.Event, len(msg))
+	for i, m := range msg {
+		e, err := p.encode(ctx, m)
+		if err != nil {
+			return err
+		}
+		events[i] = e
+	}
+	return p.wrap.BatchSend(ctx, events)
+}
+
+func (p *Producer) encode(ctx context.Context, e event.Event) (*event.Message, error) {
+	h := event.CloneHeader(e.Header())
+	attrs := Attributes{
+		Id:              h.Get(event.HeaderId),
+		Source:          p.source,
+		SpecVersion:     SpecVersion,
+		Type:            p.opt.typ(e),
+		Subject:         p.opt.subject(e),
+		DataContentType: h.Get(event.HeaderContentType),
+		Extensions:      map[string]string{},
+	}
+	if len(attrs.Id) == 0 {
+		attrs.Id = uuid.New().String()
+	}
+	attrs.Time, _ = time.Parse(time.RFC3339Nano, h.Get(event.HeaderTime))
+	if attrs.Time.IsZero() {
+		attrs.Time = time.Now()
+	}
+	if u, ok := uow.FromCurrentUow(ctx); ok {
+		attrs.Extensions[ExtensionUowId] = u.GetId()
+	}
+	return Encode(e, attrs, p.opt.mode)
+}
@@ -0,0 +1,166 @@
+package cloudevents
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jace996/uow"
+	"github.com/jace996/uow/event"
+	"github.com/jace996/uow/mock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type recordProducer struct {
+	sent []event.Event
+}
+
+func (p *recordProducer) Close() error {
+	return nil
+}
+
+func (p *recordProducer) Send(ctx context.Context, msg event.Event) error {
+	return p.BatchSend(ctx, []event.Event{msg})
+}
+
+func (p *recordProducer) BatchSend(ctx context.Context, msg []event.Event) error {
+	p.sent = append(p.sent, msg...)
+	return nil
+}
+
+func testAttributes() Attributes {
+	return Attributes{
+		Id:              "1",
+		Source:          "/test",
+		Type:            "user.created",
+		Subject:         "user/1",
+		Time:            time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+		DataContentType: "application/json",
+		Extensions:      map[string]string{"tenant": "a"},
+	}
+}
+
+func TestBinary(t *testing.T) {
+	e := event.NewBuilder("user").Value([]byte(`{"id":1}`)).Header("X-Other", "o").Build()
+	b, err := Encode(e, testAttributes(), Binary)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", b.Header().Get("ce-id"))
+	assert.Equal(t, "1.0", b.Header().Get("ce-specversion"))
+	assert.Equal(t, "2022-01-01T00:00:00Z", b.Header().Get("ce-time"))
+	assert.Equal(t, "a", b.Header().Get("ce-tenant"))
+	assert.Equal(t, "application/json", b.Header().Get(event.HeaderContentType))
+	assert.Equal(t, e.Value(), b.Value())
+
+	attrs, m, err := Decode(b)
+	assert.NoError(t, err)
+	expected := testAttributes()
+	expected.SpecVersion = SpecVersion
+	assert.Equal(t, expected, attrs)
+	assert.Equal(t, e.Value(), m.Value())
+	assert.Equal(t, "o", m.Header().Get("x-other"))
+	assert.Empty(t, m.Header().Get("ce-id"))
+}
+
+func TestStructured(t *testing.T) {
+	e := event.NewBuilder("user").Value([]byte(`{"id":1}`)).Build()
+	s, err := Encode(e, testAttributes(), Structured)
+	assert.NoError(t, err)
+	assert.Equal(t, ContentTypeStructured, s.Header().Get(event.HeaderContentType))
+	var env map[string]interface{}
+	assert.NoError(t, json.Unmarshal(s.Value(), &env))
+	assert.Equal(t, "user.created", env["type"])
+	assert.Equal(t, map[string]interface{}{"id": float64(1)}, env["data"])
+	assert.Equal(t, "a", env["tenant"])
+
+	attrs, m, err := Decode(s)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", attrs.Id)
+	assert.Equal(t, "a", attrs.Extensions["tenant"])
+	assert.JSONEq(t, `{"id":1}`, string(m.Value()))
+	assert.Equal(t, "application/json", m.Header().Get(event.HeaderContentType))
+
+	//binary data
+	attrs = testAttributes()
+	attrs.DataContentType = "application/octet-stream"
+	s, err = Encode(event.NewMessage("bin", []byte{0, 1, 2}), attrs, Structured)
+	assert.NoError(t, err)
+	assert.Contains(t, string(s.Value()), "data_base64")
+	_, m, err = Decode(s)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 2}, m.Value())
+}
+
+func TestInvalid(t *testing.T) {
+	_, err := Encode(event.NewMessage("user", nil), Attributes{Id: "1"}, Binary)
+	assert.ErrorIs(t, err, ErrInvalid)
+	attrs := testAttributes()
+	attrs.Extensions = map[string]string{"Bad-Name": "a"}
+	_, err = Encode(event.NewMessage("user", nil), attrs, Binary)
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, _, err = Decode(event.NewMessage("user", nil))
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestProducer(t *testing.T) {
+	dst := &recordProducer{}
+	p := NewProducer(dst, "/users")
+	mgr := mock.NewFakeManager()
+	var uowId string
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		u, _ := uow.FromCurrentUow(ctx)
+		uowId = u.GetId()
+		e, err := event.NewTyped("user", map[string]int{"id": 1}, event.JSONCodec)
+		if err != nil {
+			return err
+		}
+		e.Header().Set(event.HeaderType, "user.created")
+		return p.Send(ctx, e)
+	})
+	assert.NoError(t, err)
+	assert.Len(t, dst.sent, 1)
+	attrs, _, err := Decode(dst.sent[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "/users", attrs.Source)
+	assert.Equal(t, "user.created", attrs.Type)
+	assert.Equal(t, "user", attrs.Subject)
+	assert.Equal(t, dst.sent[0].Header().Get(event.HeaderId), attrs.Id)
+	assert.False(t, attrs.Time.IsZero())
+	assert.Equal(t, "application/json", attrs.DataContentType)
+	assert.Equal(t, uowId, attrs.Extensions[ExtensionUowId])
+
+	p = NewProducer(dst, "/users", WithMode(Structured))
+	assert.NoError(t, p.BatchSend(context.Background(), []event.Event{event.NewMessage("user", []byte(`1`))}))
+	attrs, _, err = Decode(dst.sent[1])
+	assert.NoError(t, err)
+	assert.Equal(t, "user", attrs.Type)
+	assert.Empty(t, attrs.Extensions[ExtensionUowId])
+}
+
+// bareEvent has no header
+type bareEvent struct {
+	key   string
+	value []byte
+}
+
+func (e *bareEvent) Header() event.Header {
+	return nil
+}
+
+func (e *bareEvent) Key() string {
+	return e.key
+}
+
+func (e *bareEvent) Value() []byte {
+	return e.value
+}
+
+func TestProducerWithoutHeader(t *testing.T) {
+	dst := &recordProducer{}
+	p := NewProducer(dst, "/users")
+	assert.NoError(t, p.Send(context.Background(), &bareEvent{key: "user", value: []byte(`1`)}))
+	attrs, msg, err := Decode(dst.sent[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "user", attrs.Type)
+	assert.NotEmpty(t, attrs.Id)
+	assert.Equal(t, []byte(`1`), msg.Value())
+}
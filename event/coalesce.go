@@ -0,0 +1,71 @@
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Merge combines two events of the same key. prev is sent earlier than next
+type Merge func(prev, next Event) Event
+
+var (
+	// KeepFirst keeps the earliest event of a key
+	KeepFirst Merge = func(prev, next Event) Event { return prev }
+	// KeepLast keeps the latest event of a key
+	KeepLast Merge = func(prev, next Event) Event { return next }
+)
+
+// Coalesce merges events by Key(). The merged event of a key takes the position of its last event,
+// so it is sent after every event which was sent before any of the merged ones
+func Coalesce(events []Event, merge Merge) []Event {
+	merged := map[string]Event{}
+	last := map[string]int{}
+	for i, e := range events {
+		if prev, ok := merged[e.Key()]; ok {
+			merged[e.Key()] = merge(prev, e)
+		} else {
+			merged[e.Key()] = e
+		}
+		last[e.Key()] = i
+	}
+	ret := make([]Event, 0, len(merged))
+	for i, e := range events {
+		if last[e.Key()] == i {
+			ret = append(ret, merged[e.Key()])
+		}
+	}
+	return ret
+}
+
+// Dedup drops exact duplicates keeping the first one. Events are duplicates if key, value and headers are equal,
+// ignoring HeaderId and HeaderTime which are unique per event
+func Dedup(events []Event) []Event {
+	seen := map[string][]Event{}
+	ret := make([]Event, 0, len(events))
+	for _, e := range events {
+		fp := fingerprint(e)
+		dup := false
+		for _, s := range seen[fp] {
+			if bytes.Equal(s.Value(), e.Value()) {
+				dup = true
+				break
+			}
+		}
+		if dup {
+			continue
+		}
+		seen[fp] = append(seen[fp], e)
+		ret = append(ret, e)
+	}
+	return ret
+}
+
+// fingerprint of key and headers
+func fingerprint(e Event) string {
+	h := CloneHeader(e.Header())
+	h.Del(HeaderId)
+	h.Del(HeaderTime)
+	//keys of map are sorted by json
+	b, _ := json.Marshal(h)
+	return e.Key() + "\x00" + string(b)
+}
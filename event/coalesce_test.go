@@ -0,0 +1,62 @@
+package event
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func values(events []Event) []string {
+	var ret []string
+	for _, e := range events {
+		ret = append(ret, e.Key()+":"+string(e.Value()))
+	}
+	return ret
+}
+
+func TestCoalesce(t *testing.T) {
+	events := []Event{
+		NewMessage("user", []byte("1")),
+		NewMessage("order", []byte("1")),
+		NewMessage("user", []byte("2")),
+		NewMessage("stock", []byte("1")),
+	}
+	assert.Equal(t, []string{"order:1", "user:2", "stock:1"}, values(Coalesce(events, KeepLast)))
+	assert.Equal(t, []string{"order:1", "user:1", "stock:1"}, values(Coalesce(events, KeepFirst)))
+	concat := func(prev, next Event) Event {
+		return NewMessage(prev.Key(), append(append([]byte(nil), prev.Value()...), next.Value()...))
+	}
+	assert.Equal(t, []string{"order:1", "user:12", "stock:1"}, values(Coalesce(events, concat)))
+}
+
+func TestDedup(t *testing.T) {
+	withHeader := NewMessage("user", []byte("1"))
+	withHeader.Header().Set("h", "v")
+	events := []Event{
+		NewMessage("user", []byte("1")),
+		NewMessage("user", []byte("2")),
+		//different id and time
+		NewMessage("user", []byte("1")),
+		withHeader,
+		NewMessage("order", []byte("1")),
+	}
+	ret := Dedup(events)
+	assert.Equal(t, []string{"user:1", "user:2", "user:1", "order:1"}, values(ret))
+	assert.Same(t, events[0], ret[0])
+	assert.Same(t, withHeader, ret[2])
+}
+
+func TestTransactionalCoalesce(t *testing.T) {
+	p := &recordProducer{}
+	mgr := newRecordManager(p, WithDedup(), WithCoalesce(KeepLast))
+	transP := NewTransactionalProducer(p, []string{"event"})
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		return transP.BatchSend(ctx, []Event{
+			NewMessage("user", []byte("1")),
+			NewMessage("order", []byte("1")),
+			NewMessage("user", []byte("2")),
+		})
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"order", "user"}}, p.sent)
+}
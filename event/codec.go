@@ -0,0 +1,242 @@
+package event
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"reflect"
+	"sync"
+)
+
+const (
+	// HeaderContentType is the header key of the content type of event value
+	HeaderContentType = "Content-Type"
+	// HeaderType is the header key of the event type name
+	HeaderType = "Event-Type"
+)
+
+var (
+	ErrUnknownContentType = errors.New("event: unknown content type")
+	ErrUnknownType        = errors.New("event: unknown event type")
+)
+
+// Codec encodes and decodes event payloads
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type protoCodec struct{}
+
+func (protoCodec) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("event: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("event: %T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string {
+	return "application/x-gob"
+}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+var (
+	JSONCodec  Codec = jsonCodec{}
+	ProtoCodec Codec = protoCodec{}
+	GobCodec   Codec = gobCodec{}
+)
+
+var (
+	codecMtx sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+func init() {
+	RegisterCodec(JSONCodec)
+	RegisterCodec(ProtoCodec)
+	RegisterCodec(GobCodec)
+}
+
+// RegisterCodec register c by its content type, so Decode can find it
+func RegisterCodec(c Codec) {
+	codecMtx.Lock()
+	defer codecMtx.Unlock()
+	codecs[c.ContentType()] = c
+}
+
+// CodecFor returns the codec registered for contentType
+func CodecFor(contentType string) (Codec, bool) {
+	codecMtx.RLock()
+	defer codecMtx.RUnlock()
+	c, ok := codecs[contentType]
+	return c, ok
+}
+
+// NewTyped create a Message with payload encoded by codec. Content type and event type headers are set automatically,
+// the event type is the name registered in DefaultRegistry or the Go type name. JSONCodec is used if codec is nil
+func NewTyped[T any](key string, payload T, codec Codec) (*Message, error) {
+	if codec == nil {
+		codec = JSONCodec
+	}
+	value, err := codec.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return NewBuilder(key).
+		Value(value).
+		Header(HeaderContentType, codec.ContentType()).
+		Header(HeaderType, DefaultRegistry.nameOf(reflect.TypeOf(&payload).Elem())).
+		Build(), nil
+}
+
+// Decode the value of e into T with the codec of its content type. JSONCodec is used if content type is absent
+func Decode[T any](e Event) (T, error) {
+	var zero T
+	v, err := decode(e, reflect.TypeOf(&zero).Elem())
+	if err != nil {
+		return zero, err
+	}
+	return v.Interface().(T), nil
+}
+
+func codecOf(e Event) (Codec, error) {
+	var contentType string
+	if h := e.Header(); h != nil {
+		contentType = h.Get(HeaderContentType)
+	}
+	if len(contentType) == 0 {
+		return JSONCodec, nil
+	}
+	c, ok := CodecFor(contentType)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownContentType, contentType)
+	}
+	return c, nil
+}
+
+// decode e into a new value of typ
+func decode(e Event, typ reflect.Type) (reflect.Value, error) {
+	c, err := codecOf(e)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if typ.Kind() == reflect.Ptr {
+		//e.g. proto messages
+		ptr := reflect.New(typ.Elem())
+		if err := c.Unmarshal(e.Value(), ptr.Interface()); err != nil {
+			return reflect.Value{}, err
+		}
+		return ptr, nil
+	}
+	ptr := reflect.New(typ)
+	if err := c.Unmarshal(e.Value(), ptr.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+	return ptr.Elem(), nil
+}
+
+// TypeRegistry maps event type names to Go types, so consumers can decode events without knowing their types in advance
+type TypeRegistry struct {
+	mtx    sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}
+
+var DefaultRegistry = NewTypeRegistry()
+
+func NewTypeRegistry() *TypeRegistry {
+	return &TypeRegistry{
+		byName: map[string]reflect.Type{},
+		byType: map[reflect.Type]string{},
+	}
+}
+
+// Register T with name into r
+func Register[T any](r *TypeRegistry, name string) {
+	var zero T
+	typ := reflect.TypeOf(&zero).Elem()
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.byName[name] = typ
+	r.byType[typ] = name
+}
+
+// Name returns the registered name of v's type
+func (r *TypeRegistry) Name(v interface{}) (string, bool) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	name, ok := r.byType[reflect.TypeOf(v)]
+	return name, ok
+}
+
+func (r *TypeRegistry) nameOf(typ reflect.Type) string {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	if name, ok := r.byType[typ]; ok {
+		return name
+	}
+	return typ.String()
+}
+
+// Decode the value of e into a new value of the type registered by its event type header
+func (r *TypeRegistry) Decode(e Event) (interface{}, error) {
+	var name string
+	if h := e.Header(); h != nil {
+		name = h.Get(HeaderType)
+	}
+	r.mtx.RLock()
+	typ, ok := r.byName[name]
+	r.mtx.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownType, name)
+	}
+	v, err := decode(e, typ)
+	if err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
@@ -0,0 +1,71 @@
+package event
+
+import (
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"testing"
+)
+
+type userCreated struct {
+	Id   int
+	Name string
+}
+
+func TestTyped(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec, GobCodec} {
+		e, err := NewTyped("user", userCreated{Id: 1, Name: "a"}, codec)
+		assert.NoError(t, err)
+		assert.Equal(t, codec.ContentType(), e.Header().Get(HeaderContentType))
+		assert.Equal(t, "event.userCreated", e.Header().Get(HeaderType))
+		v, err := Decode[userCreated](e)
+		assert.NoError(t, err)
+		assert.Equal(t, userCreated{Id: 1, Name: "a"}, v)
+	}
+
+	e, err := NewTyped("user", wrapperspb.String("a"), ProtoCodec)
+	assert.NoError(t, err)
+	v, err := Decode[*wrapperspb.StringValue](e)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", v.GetValue())
+
+	_, err = NewTyped("user", userCreated{}, ProtoCodec)
+	assert.Error(t, err)
+
+	e.Header().Set(HeaderContentType, "unknown")
+	_, err = Decode[*wrapperspb.StringValue](e)
+	assert.ErrorIs(t, err, ErrUnknownContentType)
+}
+
+func TestTypeRegistry(t *testing.T) {
+	r := NewTypeRegistry()
+	Register[userCreated](r, "user.created")
+	Register[*wrapperspb.StringValue](r, "string")
+	name, ok := r.Name(userCreated{})
+	assert.True(t, ok)
+	assert.Equal(t, "user.created", name)
+
+	e := NewBuilder("user").Value([]byte(`{"Id":1}`)).Header(HeaderType, "user.created").Build()
+	v, err := r.Decode(e)
+	assert.NoError(t, err)
+	assert.Equal(t, userCreated{Id: 1}, v)
+
+	pe, err := NewTyped("user", wrapperspb.String("a"), ProtoCodec)
+	assert.NoError(t, err)
+	pe.Header().Set(HeaderType, "string")
+	v, err = r.Decode(pe)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", v.(*wrapperspb.StringValue).GetValue())
+
+	e.Header().Set(HeaderType, "unknown")
+	_, err = r.Decode(e)
+	assert.ErrorIs(t, err, ErrUnknownType)
+
+	//registered in default registry
+	Register[userCreated](DefaultRegistry, "user.created")
+	defer func() {
+		DefaultRegistry = NewTypeRegistry()
+	}()
+	e, err = NewTyped("user", userCreated{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "user.created", e.Header().Get(HeaderType))
+}
@@ -0,0 +1,62 @@
+package event
+
+import (
+	"context"
+	"github.com/jace996/uow"
+	"sync"
+)
+
+// Dispatcher is an in-process bus of domain events. Events raised inside a unit of work are handled synchronously
+// by handlers registered for their key, right before the unit of work commits, so handlers write in the same transactions.
+// Events raised by handlers are handled in the same commit, and a failed handler rolls the unit of work back.
+// Integration events should be sent by TransactionalProducer, e.g. with Forward, so they go out after the data of the unit of work is committed
+type Dispatcher struct {
+	mtx      sync.RWMutex
+	handlers map[string][]Handler
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{handlers: map[string][]Handler{}}
+}
+
+// Register handlers of events with key. Handlers are called in registration order
+func (d *Dispatcher) Register(key string, h ...Handler) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.handlers[key] = append(d.handlers[key], h...)
+}
+
+// Raise queues events into current unit of work. They are dispatched before it commits
+func (d *Dispatcher) Raise(ctx context.Context, e ...Event) error {
+	u, ok := uow.FromCurrentUow(ctx)
+	if !ok {
+		return uow.ErrUnitOfWorkNotFound
+	}
+	for _, ev := range e {
+		ev := ev
+		u.OnBeforeCommit(func() error {
+			return d.Dispatch(ctx, ev)
+		})
+	}
+	return nil
+}
+
+// Dispatch calls handlers of e immediately, stops at the first error
+func (d *Dispatcher) Dispatch(ctx context.Context, e Event) error {
+	d.mtx.RLock()
+	handlers := d.handlers[e.Key()]
+	d.mtx.RUnlock()
+	for _, h := range handlers {
+		if err := h(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Forward returns a Handler sending events to p, e.g. publishing a domain event as integration event through TransactionalProducer
+func Forward(p Producer) Handler {
+	return func(ctx context.Context, e Event) error {
+		return p.Send(ctx, e)
+	}
+}
@@ -0,0 +1,94 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"github.com/jace996/uow"
+	"github.com/jace996/uow/mock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDispatcher(t *testing.T) {
+	p := &recordProducer{}
+	mgr := newRecordManager(p)
+	transP := NewTransactionalProducer(p, []string{"event"})
+	d := NewDispatcher()
+	var handled []string
+	d.Register("order.created", func(ctx context.Context, e Event) error {
+		handled = append(handled, e.Key())
+		//raise further events
+		return d.Raise(ctx, NewMessage("stock.reserved", nil))
+	})
+	d.Register("stock.reserved", func(ctx context.Context, e Event) error {
+		handled = append(handled, e.Key())
+		return nil
+	}, Forward(transP))
+
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		if err := d.Raise(ctx, NewMessage("order.created", nil)); err != nil {
+			return err
+		}
+		//handled before commit
+		assert.Empty(t, handled)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"order.created", "stock.reserved"}, handled)
+	assert.Equal(t, [][]string{{"stock.reserved"}}, p.sent)
+
+	assert.ErrorIs(t, d.Raise(context.Background(), NewMessage("order.created", nil)), uow.ErrUnitOfWorkNotFound)
+}
+
+func TestDispatcherRollback(t *testing.T) {
+	p := &recordProducer{}
+	mgr := newRecordManager(p)
+	transP := NewTransactionalProducer(p, []string{"event"})
+	d := NewDispatcher()
+	fail := errors.New("out of stock")
+	d.Register("order.created", Forward(transP), func(ctx context.Context, e Event) error {
+		return fail
+	})
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		return d.Raise(ctx, NewMessage("order.created", nil))
+	})
+	assert.ErrorIs(t, err, fail)
+	assert.Empty(t, p.sent)
+}
+
+// commitCheckProducer records whether db was committed when events are sent
+type commitCheckProducer struct {
+	recordProducer
+	recorder  *mock.Recorder
+	committed []bool
+}
+
+func (p *commitCheckProducer) BatchSend(ctx context.Context, msg []Event) error {
+	p.committed = append(p.committed, p.recorder.Committed("db"))
+	return p.recordProducer.BatchSend(ctx, msg)
+}
+
+func TestDispatcherSendAfterCommit(t *testing.T) {
+	r := mock.NewRecorder()
+	p := &commitCheckProducer{recorder: r}
+	mgr := uow.NewManager(func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+		if keys[0] == "event" {
+			return NewTransactional(ctx, p), nil
+		}
+		return mock.NewFakeDb(keys[0], r), nil
+	})
+	transP := NewTransactionalProducer(p, []string{"event"})
+	d := NewDispatcher()
+	d.Register("order.created", Forward(transP))
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		u, _ := uow.FromCurrentUow(ctx)
+		if _, err := u.GetTxDb(ctx, "db"); err != nil {
+			return err
+		}
+		return d.Raise(ctx, NewMessage("order.created", nil))
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"order.created"}}, p.sent)
+	//event resource is resolved last by the handler, but still sent after db commits
+	assert.Equal(t, []bool{true}, p.committed)
+}
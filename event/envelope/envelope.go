@@ -0,0 +1,157 @@
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/jace996/uow/event"
+)
+
+const (
+	// HeaderEncryption is the header key of the encryption algorithm of Value()
+	HeaderEncryption = "Encryption"
+	// HeaderKeyId is the header key of the id of the encryption key
+	HeaderKeyId = "Encryption-Key-Id"
+
+	AESGCM = "AES-GCM"
+)
+
+var (
+	ErrKeyNotFound          = errors.New("envelope: key not found")
+	ErrUnsupportedAlgorithm = errors.New("envelope: unsupported algorithm")
+	ErrMalformed            = errors.New("envelope: malformed ciphertext")
+)
+
+// KeyProvider provides AES keys of 16, 24 or 32 bytes
+type KeyProvider interface {
+	// Current returns the key used to encrypt new events
+	Current(ctx context.Context) (id string, key []byte, err error)
+	// Key returns the key of id to decrypt events
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider of fixed keys. Keep old keys to decrypt events encrypted before rotation
+type StaticKeys struct {
+	current string
+	keys    map[string][]byte
+}
+
+var _ KeyProvider = (*StaticKeys)(nil)
+
+// NewStaticKeys create StaticKeys encrypting with keys[current]
+func NewStaticKeys(current string, keys map[string][]byte) *StaticKeys {
+	return &StaticKeys{current: current, keys: keys}
+}
+
+func (s *StaticKeys) Current(ctx context.Context) (string, []byte, error) {
+	key, err := s.Key(ctx, s.current)
+	return s.current, key, err
+}
+
+func (s *StaticKeys) Key(ctx context.Context, id string) ([]byte, error) {
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+	}
+	return key, nil
+}
+
+// Encrypt returns a copy of e whose value is encrypted by AES-GCM with the current key of keys.
+// The ciphertext is bound to the key and id of the event, so it can not be moved into another event
+func Encrypt(ctx context.Context, keys KeyProvider, e event.Event) (event.Event, error) {
+	m := event.FromEvent(e)
+	id, key, err := keys.Current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ciphertext := aead.Seal(nonce, nonce, m.Value(), additionalData(m))
+	ret := m.ToBuilder().Value(ciphertext).Build()
+	ret.Header().Set(HeaderEncryption, AESGCM)
+	ret.Header().Set(HeaderKeyId, id)
+	return ret, nil
+}
+
+// Decrypt returns a copy of e with plain value. Events which are not encrypted are returned as is
+func Decrypt(ctx context.Context, keys KeyProvider, e event.Event) (event.Event, error) {
+	h := e.Header()
+	if h == nil || len(h.Get(HeaderEncryption)) == 0 {
+		return e, nil
+	}
+	if alg := h.Get(HeaderEncryption); alg != AESGCM {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+	}
+	key, err := keys.Key(ctx, h.Get(HeaderKeyId))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	m := event.FromEvent(e)
+	value := m.Value()
+	if len(value) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	plain, err := aead.Open(nil, value[:aead.NonceSize()], value[aead.NonceSize():], additionalData(m))
+	if err != nil {
+		return nil, err
+	}
+	ret := m.ToBuilder().Value(plain).Build()
+	ret.Header().(event.MapHeader).Del(HeaderEncryption)
+	ret.Header().(event.MapHeader).Del(HeaderKeyId)
+	return ret, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// additionalData is the length prefixed key and id of m
+func additionalData(m *event.Message) []byte {
+	return appendFields(nil, []byte(m.Key()), []byte(m.Id()))
+}
+
+func appendFields(b []byte, fields ...[]byte) []byte {
+	for _, f := range fields {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(f)))
+		b = append(b, f...)
+	}
+	return b
+}
+
+// EncryptInterceptor encrypts every sent event
+func EncryptInterceptor(keys KeyProvider) event.Interceptor {
+	return event.Transform(func(ctx context.Context, e event.Event) (event.Event, error) {
+		return Encrypt(ctx, keys, e)
+	})
+}
+
+// DecryptMiddleware decrypts every received event before handling
+func DecryptMiddleware(keys KeyProvider) event.Middleware {
+	return func(next event.Handler) event.Handler {
+		return func(ctx context.Context, e event.Event) error {
+			plain, err := Decrypt(ctx, keys, e)
+			if err != nil {
+				return err
+			}
+			return next(ctx, plain)
+		}
+	}
+}
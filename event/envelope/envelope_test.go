@@ -0,0 +1,84 @@
+package envelope
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/jace996/uow/event"
+	"github.com/jace996/uow/event/memory"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var (
+	key1 = []byte("0123456789abcdef0123456789abcdef")
+	key2 = []byte("fedcba9876543210fedcba9876543210")
+)
+
+func TestEncryptAndSign(t *testing.T) {
+	keys := NewStaticKeys("k1", map[string][]byte{"k1": key1})
+	signer := NewHMAC("s1", []byte("secret"))
+	b := memory.NewBroker()
+	defer b.Close()
+	p := event.Chain(b, EncryptInterceptor(keys), SignInterceptor(signer))
+	msg := event.NewMessage("user.created", []byte(`{"email":"a@b.c"}`))
+	assert.NoError(t, p.Send(context.Background(), msg))
+
+	sent := b.Published()[0]
+	assert.NotContains(t, string(sent.Value()), "a@b.c")
+	assert.Equal(t, "k1", sent.Header().Get(HeaderKeyId))
+	assert.Equal(t, msg.Id(), sent.Header().Get(event.HeaderId))
+
+	var got event.Event
+	h := event.ChainHandler(func(ctx context.Context, e event.Event) error {
+		got = e
+		return nil
+	}, VerifyMiddleware(signer), DecryptMiddleware(keys))
+	assert.NoError(t, h(context.Background(), sent))
+	assert.Equal(t, msg.Value(), got.Value())
+	assert.Empty(t, got.Header().Get(HeaderEncryption))
+
+	//tampered value
+	tampered := event.FromEvent(sent).ToBuilder().Value(append([]byte("x"), sent.Value()...)).Build()
+	assert.ErrorIs(t, h(context.Background(), tampered), ErrInvalidSignature)
+	//missing signature
+	assert.ErrorIs(t, h(context.Background(), msg), ErrMissingSignature)
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := NewStaticKeys("k1", map[string][]byte{"k1": key1})
+	encrypted, err := Encrypt(context.Background(), old, event.NewMessage("user.created", []byte("plain")))
+	assert.NoError(t, err)
+
+	rotated := NewStaticKeys("k2", map[string][]byte{"k1": key1, "k2": key2})
+	plain, err := Decrypt(context.Background(), rotated, encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("plain"), plain.Value())
+
+	_, err = Decrypt(context.Background(), NewStaticKeys("k2", map[string][]byte{"k2": key2}), encrypted)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	//ciphertext is bound to the event
+	moved := event.NewBuilder("user.deleted").Value(encrypted.Value()).Headers(encrypted.Header()).Build()
+	_, err = Decrypt(context.Background(), rotated, moved)
+	assert.Error(t, err)
+
+	//not encrypted
+	e := event.NewMessage("user.created", []byte("plain"))
+	plain, err = Decrypt(context.Background(), rotated, e)
+	assert.NoError(t, err)
+	assert.Same(t, e, plain)
+}
+
+func TestEd25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	signed, err := Sign(NewEd25519Signer("e1", priv), event.NewMessage("user.created", []byte("plain")))
+	assert.NoError(t, err)
+	assert.NoError(t, Verify(Ed25519Verifier{"e1": pub}, signed))
+	assert.ErrorIs(t, Verify(Ed25519Verifier{}, signed), ErrKeyNotFound)
+
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, Verify(Ed25519Verifier{"e1": otherPub}, signed), ErrInvalidSignature)
+}
@@ -0,0 +1,158 @@
+package envelope
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/jace996/uow/event"
+)
+
+const (
+	// HeaderSignature is the header key of the base64 signature of an event
+	HeaderSignature = "Signature"
+	// HeaderSignatureKeyId is the header key of the id of the signing key
+	HeaderSignatureKeyId = "Signature-Key-Id"
+)
+
+var (
+	ErrMissingSignature = errors.New("envelope: missing signature")
+	ErrInvalidSignature = errors.New("envelope: invalid signature")
+)
+
+// Signer signs events with the key of KeyId
+type Signer interface {
+	KeyId() string
+	Sign(data []byte) ([]byte, error)
+}
+
+// Verifier verifies signatures made by the key of keyId
+type Verifier interface {
+	Verify(keyId string, data, sig []byte) error
+}
+
+// HMAC signs and verifies with HMAC-SHA256
+type HMAC struct {
+	keyId  string
+	secret []byte
+}
+
+var (
+	_ Signer   = (*HMAC)(nil)
+	_ Verifier = (*HMAC)(nil)
+)
+
+func NewHMAC(keyId string, secret []byte) *HMAC {
+	return &HMAC{keyId: keyId, secret: secret}
+}
+
+func (h *HMAC) KeyId() string {
+	return h.keyId
+}
+
+func (h *HMAC) Sign(data []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+func (h *HMAC) Verify(keyId string, data, sig []byte) error {
+	if keyId != h.keyId {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, keyId)
+	}
+	expected, _ := h.Sign(data)
+	if !hmac.Equal(expected, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Ed25519Signer signs with an ed25519 private key
+type Ed25519Signer struct {
+	keyId string
+	key   ed25519.PrivateKey
+}
+
+var _ Signer = (*Ed25519Signer)(nil)
+
+func NewEd25519Signer(keyId string, key ed25519.PrivateKey) *Ed25519Signer {
+	return &Ed25519Signer{keyId: keyId, key: key}
+}
+
+func (s *Ed25519Signer) KeyId() string {
+	return s.keyId
+}
+
+func (s *Ed25519Signer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, data), nil
+}
+
+// Ed25519Verifier verifies with public keys by key id
+type Ed25519Verifier map[string]ed25519.PublicKey
+
+var _ Verifier = (Ed25519Verifier)(nil)
+
+func (v Ed25519Verifier) Verify(keyId string, data, sig []byte) error {
+	key, ok := v[keyId]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, keyId)
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Sign returns a copy of e with signature headers. Key, id, value and encryption headers are signed,
+// other headers may be changed along the way, e.g. by propagators
+func Sign(s Signer, e event.Event) (event.Event, error) {
+	m := event.FromEvent(e)
+	m.Header().Set(HeaderSignatureKeyId, s.KeyId())
+	sig, err := s.Sign(signedData(m))
+	if err != nil {
+		return nil, err
+	}
+	m.Header().Set(HeaderSignature, base64.StdEncoding.EncodeToString(sig))
+	return m, nil
+}
+
+// Verify checks the signature of e
+func Verify(v Verifier, e event.Event) error {
+	h := e.Header()
+	if h == nil || len(h.Get(HeaderSignature)) == 0 {
+		return ErrMissingSignature
+	}
+	sig, err := base64.StdEncoding.DecodeString(h.Get(HeaderSignature))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err.Error())
+	}
+	return v.Verify(h.Get(HeaderSignatureKeyId), signedData(event.FromEvent(e)), sig)
+}
+
+func signedData(m *event.Message) []byte {
+	h := m.Header()
+	return appendFields(nil, []byte(m.Key()), []byte(m.Id()), m.Value(),
+		[]byte(h.Get(HeaderEncryption)), []byte(h.Get(HeaderKeyId)), []byte(h.Get(HeaderSignatureKeyId)))
+}
+
+// SignInterceptor signs every sent event. Put it after EncryptInterceptor to sign the ciphertext
+func SignInterceptor(s Signer) event.Interceptor {
+	return event.Transform(func(ctx context.Context, e event.Event) (event.Event, error) {
+		return Sign(s, e)
+	})
+}
+
+// VerifyMiddleware rejects received events without valid signature. Put it before DecryptMiddleware
+func VerifyMiddleware(v Verifier) event.Middleware {
+	return func(next event.Handler) event.Handler {
+		return func(ctx context.Context, e event.Event) error {
+			if err := Verify(v, e); err != nil {
+				return err
+			}
+			return next(ctx, e)
+		}
+	}
+}
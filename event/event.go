@@ -5,6 +5,11 @@ import (
 	"io"
 )
 
+const (
+	// HeaderId is the header key of the unique id of an event. Consumers use it for deduplication
+	HeaderId = "Event-Id"
+)
+
 type Header interface {
 	Get(key string) string
 	Set(key string, value string)
@@ -22,3 +27,12 @@ type Producer interface {
 	Send(ctx context.Context, msg Event) error
 	BatchSend(ctx context.Context, msg []Event) error
 }
+
+// TxProducer is a Producer backed by broker-native transactions. Events sent with the context returned by BeginTxn
+// are delivered eagerly but only become visible to consumers after CommitTxn, and are discarded by AbortTxn
+type TxProducer interface {
+	Producer
+	BeginTxn(ctx context.Context) (context.Context, error)
+	CommitTxn(ctx context.Context) error
+	AbortTxn(ctx context.Context) error
+}
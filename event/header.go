@@ -0,0 +1,134 @@
+package event
+
+import (
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// MapHeader is a case-insensitive multi-valued Header. Keys are stored in lower case
+type MapHeader map[string][]string
+
+var _ Header = (MapHeader)(nil)
+
+// NewHeader create MapHeader from key value pairs
+func NewHeader(kv ...string) MapHeader {
+	h := MapHeader{}
+	for i := 0; i+1 < len(kv); i += 2 {
+		h.Add(kv[i], kv[i+1])
+	}
+	return h
+}
+
+// Get returns the first value of key
+func (h MapHeader) Get(key string) string {
+	if v := h[strings.ToLower(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Set replaces values of key with value
+func (h MapHeader) Set(key string, value string) {
+	h[strings.ToLower(key)] = []string{value}
+}
+
+// Add appends value to key
+func (h MapHeader) Add(key string, value string) {
+	k := strings.ToLower(key)
+	h[k] = append(h[k], value)
+}
+
+// Values returns all values of key
+func (h MapHeader) Values(key string) []string {
+	return h[strings.ToLower(key)]
+}
+
+// Del deletes values of key
+func (h MapHeader) Del(key string) {
+	delete(h, strings.ToLower(key))
+}
+
+// Keys returns sorted keys
+func (h MapHeader) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Clone returns a deep copy
+func (h MapHeader) Clone() MapHeader {
+	ret := make(MapHeader, len(h))
+	for k, v := range h {
+		ret[k] = append([]string(nil), v...)
+	}
+	return ret
+}
+
+// CloneHeader copies any Header into a MapHeader. All values are copied if h has Values method
+func CloneHeader(h Header) MapHeader {
+	if h == nil {
+		return MapHeader{}
+	}
+	if m, ok := h.(MapHeader); ok {
+		return m.Clone()
+	}
+	ret := MapHeader{}
+	multi, isMulti := h.(interface{ Values(key string) []string })
+	for _, k := range h.Keys() {
+		if isMulti {
+			for _, v := range multi.Values(k) {
+				ret.Add(k, v)
+			}
+		} else {
+			ret.Set(k, h.Get(k))
+		}
+	}
+	return ret
+}
+
+// FromHTTPHeader converts http.Header
+func FromHTTPHeader(h http.Header) MapHeader {
+	ret := MapHeader{}
+	for k, v := range h {
+		for _, vv := range v {
+			ret.Add(k, vv)
+		}
+	}
+	return ret
+}
+
+// ToHTTPHeader converts Header to http.Header
+func ToHTTPHeader(h Header) http.Header {
+	ret := http.Header{}
+	for k, v := range CloneHeader(h) {
+		for _, vv := range v {
+			ret.Add(k, vv)
+		}
+	}
+	return ret
+}
+
+// FromMetadata converts gRPC metadata
+func FromMetadata(md metadata.MD) MapHeader {
+	ret := MapHeader{}
+	for k, v := range md {
+		for _, vv := range v {
+			ret.Add(k, vv)
+		}
+	}
+	return ret
+}
+
+// ToMetadata converts Header to gRPC metadata
+func ToMetadata(h Header) metadata.MD {
+	ret := metadata.MD{}
+	for k, v := range CloneHeader(h) {
+		ret.Append(k, v...)
+	}
+	return ret
+}
@@ -0,0 +1,127 @@
+package event
+
+import (
+	"context"
+	"time"
+)
+
+// SendFunc sends events. Send is called with a single event
+type SendFunc func(ctx context.Context, msg []Event) error
+
+// Interceptor wraps a SendFunc to observe or modify events. Returning without calling next drops the events
+type Interceptor func(next SendFunc) SendFunc
+
+func chainInterceptors(f SendFunc, interceptors ...Interceptor) SendFunc {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		f = interceptors[i](f)
+	}
+	return f
+}
+
+// sendTo use Send for a single event and BatchSend for others
+func sendTo(p Producer) SendFunc {
+	return func(ctx context.Context, msg []Event) error {
+		switch len(msg) {
+		case 0:
+			return nil
+		case 1:
+			return p.Send(ctx, msg[0])
+		default:
+			return p.BatchSend(ctx, msg)
+		}
+	}
+}
+
+func batchSendTo(p Producer) SendFunc {
+	return func(ctx context.Context, msg []Event) error {
+		if len(msg) == 0 {
+			return nil
+		}
+		return p.BatchSend(ctx, msg)
+	}
+}
+
+type chainProducer struct {
+	wrap         Producer
+	interceptors []Interceptor
+}
+
+// Chain wraps producer with interceptors. The first interceptor is the outermost
+func Chain(producer Producer, interceptors ...Interceptor) Producer {
+	return &chainProducer{wrap: producer, interceptors: interceptors}
+}
+
+func (c *chainProducer) Close() error {
+	return c.wrap.Close()
+}
+
+func (c *chainProducer) Send(ctx context.Context, msg Event) error {
+	return chainInterceptors(sendTo(c.wrap), c.interceptors...)(ctx, []Event{msg})
+}
+
+func (c *chainProducer) BatchSend(ctx context.Context, msg []Event) error {
+	return chainInterceptors(batchSendTo(c.wrap), c.interceptors...)(ctx, msg)
+}
+
+// Validate fails sending if any event is invalid
+func Validate(fn func(e Event) error) Interceptor {
+	return func(next SendFunc) SendFunc {
+		return func(ctx context.Context, msg []Event) error {
+			for _, e := range msg {
+				if err := fn(e); err != nil {
+					return err
+				}
+			}
+			return next(ctx, msg)
+		}
+	}
+}
+
+// EnrichHeader modifies headers of every event before sending
+func EnrichHeader(fn func(ctx context.Context, h Header)) Interceptor {
+	return func(next SendFunc) SendFunc {
+		return func(ctx context.Context, msg []Event) error {
+			for _, e := range msg {
+				if h := e.Header(); h != nil {
+					fn(ctx, h)
+				}
+			}
+			return next(ctx, msg)
+		}
+	}
+}
+
+// Inject values of context into headers by p
+func Inject(p Propagator) Interceptor {
+	return EnrichHeader(p.Inject)
+}
+
+// Transform replaces every event by fn, e.g. to encrypt payloads
+func Transform(fn func(ctx context.Context, e Event) (Event, error)) Interceptor {
+	return func(next SendFunc) SendFunc {
+		return func(ctx context.Context, msg []Event) error {
+			ret := make([]Event, len(msg))
+			for i, e := range msg {
+				var err error
+				if ret[i], err = fn(ctx, e); err != nil {
+					return err
+				}
+			}
+			return next(ctx, ret)
+		}
+	}
+}
+
+// Observe calls fn after sending with the result and duration, e.g. for logging and metrics.
+// Applied by WithInterceptors inside a unit of work, it observes buffering, so events rolled back or failed on commit are reported as sent.
+// To observe the delivery, Chain the producer of Transactional with it
+func Observe(fn func(ctx context.Context, msg []Event, err error, d time.Duration)) Interceptor {
+	return func(next SendFunc) SendFunc {
+		return func(ctx context.Context, msg []Event) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			fn(ctx, msg, err, time.Since(start))
+			return err
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestChain(t *testing.T) {
+	p := &captureProducer{}
+	var order []string
+	trace := func(name string) Interceptor {
+		return func(next SendFunc) SendFunc {
+			return func(ctx context.Context, msg []Event) error {
+				order = append(order, name)
+				return next(ctx, msg)
+			}
+		}
+	}
+	var observed int
+	invalid := errors.New("invalid")
+	chain := Chain(p,
+		trace("first"),
+		Observe(func(ctx context.Context, msg []Event, err error, d time.Duration) {
+			observed += len(msg)
+		}),
+		Validate(func(e Event) error {
+			if len(e.Key()) == 0 {
+				return invalid
+			}
+			return nil
+		}),
+		EnrichHeader(func(ctx context.Context, h Header) {
+			h.Set("X-Enriched", "1")
+		}),
+		Transform(func(ctx context.Context, e Event) (Event, error) {
+			return NewBuilder(e.Key()).Value(append([]byte("t:"), e.Value()...)).Headers(e.Header()).Build(), nil
+		}),
+		trace("last"),
+	)
+	assert.NoError(t, chain.Send(context.Background(), NewMessage("1", []byte("a"))))
+	assert.NoError(t, chain.BatchSend(context.Background(), []Event{NewMessage("2", []byte("b")), NewMessage("3", nil)}))
+	assert.ErrorIs(t, chain.Send(context.Background(), NewMessage("", nil)), invalid)
+
+	assert.Equal(t, []string{"first", "last", "first", "last", "first"}, order)
+	assert.Equal(t, 4, observed)
+	assert.Len(t, p.sent, 3)
+	assert.Equal(t, "t:a", string(p.sent[0].Value()))
+	assert.Equal(t, "1", p.sent[1].Header().Get("x-enriched"))
+}
+
+func TestTransactionalProducerInterceptors(t *testing.T) {
+	p := &captureProducer{}
+	mgr := newRecordManager(p)
+	var intercepted []string
+	invalid := errors.New("invalid")
+	transP := NewTransactionalProducer(p, []string{"event"}, WithInterceptors(
+		func(next SendFunc) SendFunc {
+			return func(ctx context.Context, msg []Event) error {
+				for _, e := range msg {
+					intercepted = append(intercepted, e.Key())
+				}
+				return next(ctx, msg)
+			}
+		},
+		Validate(func(e Event) error {
+			if e.Key() == "invalid" {
+				return invalid
+			}
+			return nil
+		}),
+	))
+
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		if err := transP.Send(ctx, NewMessage("1", nil)); err != nil {
+			return err
+		}
+		return transP.BatchSend(ctx, []Event{NewMessage("2", nil), NewMessage("3", nil)})
+	})
+	assert.NoError(t, err)
+	//buffered events are not intercepted again on commit
+	assert.Equal(t, []string{"1", "2", "3"}, intercepted)
+	assert.Len(t, p.sent, 3)
+
+	err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		return transP.Send(ctx, NewMessage("invalid", nil))
+	})
+	assert.ErrorIs(t, err, invalid)
+	assert.Len(t, p.sent, 3)
+
+	//direct
+	assert.NoError(t, transP.Send(context.Background(), NewMessage("4", nil)))
+	assert.ErrorIs(t, transP.Send(context.Background(), NewMessage("invalid", nil)), invalid)
+	assert.Equal(t, []string{"1", "2", "3", "invalid", "4", "invalid"}, intercepted)
+	assert.Len(t, p.sent, 4)
+}
+
+func TestObserveDelivery(t *testing.T) {
+	p := &recordProducer{}
+	var buffered, delivered []string
+	observe := func(observed *[]string) Interceptor {
+		return Observe(func(ctx context.Context, msg []Event, err error, d time.Duration) {
+			for _, e := range msg {
+				*observed = append(*observed, fmt.Sprintf("%s:%v", e.Key(), err))
+			}
+		})
+	}
+	fail := errors.New("broker down")
+	mgr := newRecordManager(Chain(p, observe(&delivered)))
+	transP := NewTransactionalProducer(p, []string{"event"}, WithInterceptors(observe(&buffered)))
+
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		if err := transP.Send(ctx, NewMessage("1", nil)); err != nil {
+			return err
+		}
+		return errors.New("fake error")
+	})
+	assert.Error(t, err)
+	p.fail, p.err = 1, fail
+	err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		return transP.Send(ctx, NewMessage("2", nil))
+	})
+	assert.ErrorIs(t, err, fail)
+	assert.NoError(t, mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		return transP.Send(ctx, NewMessage("3", nil))
+	}))
+
+	//interceptors of TransactionalProducer see every buffered event as sent
+	assert.Equal(t, []string{"1:<nil>", "2:<nil>", "3:<nil>"}, buffered)
+	//interceptors of the delivering producer see the real result
+	assert.Equal(t, []string{"2:broker down", "3:<nil>"}, delivered)
+	assert.Equal(t, [][]string{{"3"}}, p.sent)
+}
@@ -0,0 +1,212 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"github.com/jace996/uow/event"
+	"sync"
+)
+
+var ErrClosed = errors.New("memory: broker closed")
+
+type options struct {
+	buffer       int
+	errorHandler func(topic string, e event.Event, err error)
+}
+
+type Option func(*options)
+
+// WithBuffer set channel size of each subscription. 0 means unbuffered: Send blocks until every subscriber receives the event
+func WithBuffer(n int) Option {
+	return func(o *options) {
+		o.buffer = n
+	}
+}
+
+// WithErrorHandler called when a subscriber handler fails
+func WithErrorHandler(fn func(topic string, e event.Event, err error)) Option {
+	return func(o *options) {
+		o.errorHandler = fn
+	}
+}
+
+// Broker is an in memory channel broker for local development and tests. Topic of an event is its Key().
+// Each subscription handles events one by one, so events of a key are handled in the order they are sent
+type Broker struct {
+	opt       *options
+	mtx       sync.Mutex
+	subs      map[string][]*subscription
+	published []event.Event
+	// changed is closed and replaced whenever events are published
+	changed chan struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+var (
+	_ event.Producer   = (*Broker)(nil)
+	_ event.Subscriber = (*Broker)(nil)
+)
+
+type subscription struct {
+	topic string
+	ch    chan event.Event
+	done  chan struct{}
+}
+
+func NewBroker(opts ...Option) *Broker {
+	opt := &options{}
+	for _, o := range opts {
+		o(opt)
+	}
+	return &Broker{
+		opt:     opt,
+		subs:    map[string][]*subscription{},
+		changed: make(chan struct{}),
+	}
+}
+
+// Close stops all subscriptions and waits for running handlers
+func (b *Broker) Close() error {
+	b.mtx.Lock()
+	if b.closed {
+		b.mtx.Unlock()
+		return nil
+	}
+	b.closed = true
+	for _, subs := range b.subs {
+		for _, s := range subs {
+			close(s.done)
+		}
+	}
+	b.subs = map[string][]*subscription{}
+	b.mtx.Unlock()
+	b.wg.Wait()
+	return nil
+}
+
+func (b *Broker) Send(ctx context.Context, msg event.Event) error {
+	return b.BatchSend(ctx, []event.Event{msg})
+}
+
+func (b *Broker) BatchSend(ctx context.Context, msg []event.Event) error {
+	for _, e := range msg {
+		if err := b.publish(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Broker) publish(ctx context.Context, e event.Event) error {
+	b.mtx.Lock()
+	if b.closed {
+		b.mtx.Unlock()
+		return ErrClosed
+	}
+	subs := append([]*subscription(nil), b.subs[e.Key()]...)
+	b.published = append(b.published, e)
+	close(b.changed)
+	b.changed = make(chan struct{})
+	b.mtx.Unlock()
+	for _, s := range subs {
+		select {
+		case s.ch <- e:
+		case <-s.done:
+			//unsubscribed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Subscribe h to topic until ctx is done or broker is closed. Returns after subscribed
+func (b *Broker) Subscribe(ctx context.Context, topic string, h event.Handler) error {
+	s := &subscription{
+		topic: topic,
+		ch:    make(chan event.Event, b.opt.buffer),
+		done:  make(chan struct{}),
+	}
+	b.mtx.Lock()
+	if b.closed {
+		b.mtx.Unlock()
+		return ErrClosed
+	}
+	b.subs[topic] = append(b.subs[topic], s)
+	b.wg.Add(1)
+	b.mtx.Unlock()
+	go func() {
+		defer b.wg.Done()
+		for {
+			select {
+			case e := <-s.ch:
+				if err := event.Dispatch(ctx, e, h); err != nil && b.opt.errorHandler != nil {
+					b.opt.errorHandler(topic, e, err)
+				}
+			case <-ctx.Done():
+				b.unsubscribe(s)
+				return
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *Broker) unsubscribe(s *subscription) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	subs := b.subs[s.topic]
+	for i, ss := range subs {
+		if ss == s {
+			b.subs[s.topic] = append(subs[:i:i], subs[i+1:]...)
+			close(s.done)
+			return
+		}
+	}
+}
+
+// Published returns a copy of all sent events in order
+func (b *Broker) Published() []event.Event {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return append([]event.Event(nil), b.published...)
+}
+
+// PublishedKeys returns keys of all sent events in order
+func (b *Broker) PublishedKeys() []string {
+	var ret []string
+	for _, e := range b.Published() {
+		ret = append(ret, e.Key())
+	}
+	return ret
+}
+
+// Reset drops published events
+func (b *Broker) Reset() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.published = nil
+}
+
+// WaitFor blocks until an event of key is published and returns the first one
+func (b *Broker) WaitFor(ctx context.Context, key string) (event.Event, error) {
+	for {
+		b.mtx.Lock()
+		for _, e := range b.published {
+			if e.Key() == key {
+				b.mtx.Unlock()
+				return e, nil
+			}
+		}
+		changed := b.changed
+		b.mtx.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jace996/uow"
+	"github.com/jace996/uow/event"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	for _, buffer := range []int{0, 10} {
+		t.Run(fmt.Sprintf("buffer %d", buffer), func(t *testing.T) {
+			b := NewBroker(WithBuffer(buffer))
+			var mtx sync.Mutex
+			var received []string
+			var wg sync.WaitGroup
+			wg.Add(5)
+			err := b.Subscribe(context.Background(), "order", func(ctx context.Context, e event.Event) error {
+				mtx.Lock()
+				defer mtx.Unlock()
+				received = append(received, string(e.Value()))
+				wg.Done()
+				return nil
+			})
+			assert.NoError(t, err)
+			for i := 0; i < 5; i++ {
+				assert.NoError(t, b.Send(context.Background(), event.NewMessage("order", []byte(fmt.Sprint(i)))))
+			}
+			assert.NoError(t, b.Send(context.Background(), event.NewMessage("user", nil)))
+			wg.Wait()
+			assert.Equal(t, []string{"0", "1", "2", "3", "4"}, received)
+			assert.Len(t, b.Published(), 6)
+			assert.NoError(t, b.Close())
+			assert.ErrorIs(t, b.Send(context.Background(), event.NewMessage("order", nil)), ErrClosed)
+		})
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	b := NewBroker()
+	defer b.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	err := b.Subscribe(ctx, "order", func(ctx context.Context, e event.Event) error {
+		return nil
+	})
+	assert.NoError(t, err)
+	cancel()
+	//unbuffered send does not block after unsubscribed
+	sendCtx, sendCancel := context.WithTimeout(context.Background(), time.Second)
+	defer sendCancel()
+	assert.NoError(t, b.Send(sendCtx, event.NewMessage("order", nil)))
+}
+
+func TestErrorHandler(t *testing.T) {
+	fail := errors.New("fake error")
+	errs := make(chan error, 1)
+	b := NewBroker(WithErrorHandler(func(topic string, e event.Event, err error) {
+		errs <- err
+	}))
+	defer b.Close()
+	err := b.Subscribe(context.Background(), "order", func(ctx context.Context, e event.Event) error {
+		return fail
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, b.Send(context.Background(), event.NewMessage("order", nil)))
+	assert.ErrorIs(t, <-errs, fail)
+}
+
+func TestWaitForUow(t *testing.T) {
+	b := NewBroker()
+	defer b.Close()
+	mgr := uow.NewManager(func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+		return event.NewTransactional(ctx, b), nil
+	})
+	transP := event.NewTransactionalProducer(b, []string{"event"})
+	go func() {
+		_ = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+			if err := transP.Send(ctx, event.NewMessage("order.created", nil)); err != nil {
+				return err
+			}
+			//not published before commit
+			assert.Empty(t, b.Published())
+			return nil
+		})
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	e, err := b.WaitFor(ctx, "order.created")
+	assert.NoError(t, err)
+	assert.Equal(t, "order.created", e.Key())
+	assert.Equal(t, []string{"order.created"}, b.PublishedKeys())
+
+	b.Reset()
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = b.WaitFor(ctx, "order.created")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
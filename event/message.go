@@ -0,0 +1,143 @@
+package event
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+const (
+	// HeaderTime is the header key of the time when an event occurred, formatted as RFC3339 with nanoseconds
+	HeaderTime = "Event-Time"
+)
+
+// Message is the default Event implementation. Key and value can not be changed after built,
+// Header is metadata which can be enriched along the way, e.g. by producers
+type Message struct {
+	key    string
+	value  []byte
+	header MapHeader
+}
+
+var _ Event = (*Message)(nil)
+
+// NewMessage create a Message with auto-assigned id and time
+func NewMessage(key string, value []byte) *Message {
+	return NewBuilder(key).Value(value).Build()
+}
+
+func (m *Message) Key() string {
+	return m.key
+}
+
+func (m *Message) Value() []byte {
+	return m.value
+}
+
+func (m *Message) Header() Header {
+	return m.header
+}
+
+// Id returns the event id in header
+func (m *Message) Id() string {
+	return m.header.Get(HeaderId)
+}
+
+// Time returns the event time in header. Zero if absent or malformed
+func (m *Message) Time() time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, m.header.Get(HeaderTime))
+	return t
+}
+
+// ToBuilder returns a Builder initialized with a copy of m
+func (m *Message) ToBuilder() *Builder {
+	return &Builder{
+		key:    m.key,
+		value:  m.value,
+		header: m.header.Clone(),
+	}
+}
+
+// FromEvent copies any Event into a Message. Id and time are assigned if absent
+func FromEvent(e Event) *Message {
+	return NewBuilder(e.Key()).Value(e.Value()).Headers(e.Header()).Build()
+}
+
+// Builder builds Message
+type Builder struct {
+	key    string
+	value  []byte
+	header MapHeader
+}
+
+func NewBuilder(key string) *Builder {
+	return &Builder{key: key, header: MapHeader{}}
+}
+
+func (b *Builder) Key(key string) *Builder {
+	b.key = key
+	return b
+}
+
+func (b *Builder) Value(value []byte) *Builder {
+	b.value = value
+	return b
+}
+
+// Header adds a header value
+func (b *Builder) Header(key, value string) *Builder {
+	b.header.Add(key, value)
+	return b
+}
+
+// Headers copies all values of h
+func (b *Builder) Headers(h Header) *Builder {
+	for k, v := range CloneHeader(h) {
+		for _, vv := range v {
+			b.header.Add(k, vv)
+		}
+	}
+	return b
+}
+
+// Id set the event id. default is a random uuid
+func (b *Builder) Id(id string) *Builder {
+	b.header.Set(HeaderId, id)
+	return b
+}
+
+// Time set the event time. default is now
+func (b *Builder) Time(t time.Time) *Builder {
+	b.header.Set(HeaderTime, t.UTC().Format(time.RFC3339Nano))
+	return b
+}
+
+// DeliverAt set the time when the event should be delivered. See Scheduler
+func (b *Builder) DeliverAt(t time.Time) *Builder {
+	b.header.Set(HeaderDeliverAt, t.UTC().Format(time.RFC3339Nano))
+	return b
+}
+
+// Delay deliver the event after d from now
+func (b *Builder) Delay(d time.Duration) *Builder {
+	return b.DeliverAt(time.Now().Add(d))
+}
+
+// Build a Message. Value and header are copied so the builder can be reused
+func (b *Builder) Build() *Message {
+	header := b.header.Clone()
+	if len(header.Get(HeaderId)) == 0 {
+		header.Set(HeaderId, uuid.New().String())
+	}
+	if len(header.Get(HeaderTime)) == 0 {
+		header.Set(HeaderTime, time.Now().UTC().Format(time.RFC3339Nano))
+	}
+	var value []byte
+	if b.value != nil {
+		value = append([]byte(nil), b.value...)
+	}
+	return &Message{
+		key:    b.key,
+		value:  value,
+		header: header,
+	}
+}
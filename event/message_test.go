@@ -0,0 +1,65 @@
+package event
+
+import (
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMapHeader(t *testing.T) {
+	h := NewHeader("Content-Type", "json", "X-Multi", "a")
+	h.Add("x-multi", "b")
+	assert.Equal(t, "json", h.Get("content-type"))
+	assert.Equal(t, []string{"a", "b"}, h.Values("X-MULTI"))
+	assert.Equal(t, []string{"content-type", "x-multi"}, h.Keys())
+	h.Set("X-Multi", "c")
+	assert.Equal(t, []string{"c"}, h.Values("x-multi"))
+	h.Del("X-Multi")
+	assert.Empty(t, h.Get("x-multi"))
+
+	c := h.Clone()
+	c.Set("content-type", "xml")
+	assert.Equal(t, "json", h.Get("content-type"))
+}
+
+func TestHeaderConversion(t *testing.T) {
+	hh := http.Header{}
+	hh.Add("X-Multi", "a")
+	hh.Add("X-Multi", "b")
+	h := FromHTTPHeader(hh)
+	assert.Equal(t, []string{"a", "b"}, h.Values("x-multi"))
+	assert.Equal(t, []string{"a", "b"}, ToHTTPHeader(h).Values("X-Multi"))
+
+	md := metadata.Pairs("x-multi", "a", "x-multi", "b")
+	h = FromMetadata(md)
+	assert.Equal(t, []string{"a", "b"}, h.Values("X-Multi"))
+	assert.Equal(t, []string{"a", "b"}, ToMetadata(h).Get("x-multi"))
+}
+
+func TestMessage(t *testing.T) {
+	m := NewMessage("key", []byte("value"))
+	assert.NotEmpty(t, m.Id())
+	assert.WithinDuration(t, time.Now(), m.Time(), time.Second)
+	assert.Equal(t, m.Id(), m.Header().Get(HeaderId))
+
+	at := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewBuilder("key").Value([]byte("value")).Id("1").Time(at).Header("X-A", "a")
+	m = b.Build()
+	assert.Equal(t, "1", m.Id())
+	assert.Equal(t, at, m.Time())
+	assert.Equal(t, "a", m.Header().Get("x-a"))
+
+	//builder reuse does not affect built message
+	b.Header("X-A", "b")
+	assert.Equal(t, []string{"a"}, m.Header().(MapHeader).Values("x-a"))
+
+	m2 := m.ToBuilder().Key("key2").Build()
+	assert.Equal(t, "key2", m2.Key())
+	assert.Equal(t, "1", m2.Id())
+
+	copied := FromEvent(m)
+	assert.Equal(t, m.Id(), copied.Id())
+	assert.Equal(t, m.Value(), copied.Value())
+}
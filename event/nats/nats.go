@@ -0,0 +1,217 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jace996/uow/event"
+	"github.com/nats-io/nats.go"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultStagingStream = "UOW_STAGING"
+	DefaultStagingPrefix = "_uow.staging"
+	// HeaderSubject is the nats header of staged message holding the target subject
+	HeaderSubject = "Uow-Subject"
+)
+
+var ErrNoTxn = errors.New("nats: not in transaction")
+
+type options struct {
+	stream  string
+	prefix  string
+	maxAge  time.Duration
+	subject func(e event.Event) string
+}
+
+type Option func(*options)
+
+// WithStaging set the stream and subject prefix where events of open transactions are staged
+func WithStaging(stream, prefix string) Option {
+	return func(o *options) {
+		o.stream = stream
+		o.prefix = prefix
+	}
+}
+
+// WithStagingMaxAge set max age of staged messages, so transactions left open by crashed processes are dropped. default 1 hour
+func WithStagingMaxAge(d time.Duration) Option {
+	return func(o *options) {
+		o.maxAge = d
+	}
+}
+
+// WithSubject map event to nats subject. default is Key()
+func WithSubject(fn func(e event.Event) string) Option {
+	return func(o *options) {
+		o.subject = fn
+	}
+}
+
+// Producer publishes events into JetStream. It implements event.TxProducer:
+// events of a transaction are published into a staging stream and republished to their subjects on commit,
+// using the event id as Nats-Msg-Id so a retried commit does not duplicate them
+type Producer struct {
+	js  nats.JetStreamContext
+	opt *options
+}
+
+var _ event.TxProducer = (*Producer)(nil)
+
+// NewProducer create Producer and the staging stream if absent
+func NewProducer(js nats.JetStreamContext, opts ...Option) (*Producer, error) {
+	opt := &options{
+		stream:  DefaultStagingStream,
+		prefix:  DefaultStagingPrefix,
+		maxAge:  time.Hour,
+		subject: func(e event.Event) string { return e.Key() },
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	p := &Producer{js: js, opt: opt}
+	if _, err := js.StreamInfo(opt.stream); err != nil {
+		if !errors.Is(err, nats.ErrStreamNotFound) {
+			return nil, err
+		}
+		if _, err = js.AddStream(&nats.StreamConfig{
+			Name:     opt.stream,
+			Subjects: []string{opt.prefix + ".>"},
+			MaxAge:   opt.maxAge,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Close does nothing, the nats connection is owned by caller
+func (p *Producer) Close() error {
+	return nil
+}
+
+func (p *Producer) Send(ctx context.Context, msg event.Event) error {
+	return p.BatchSend(ctx, []event.Event{msg})
+}
+
+// BatchSend publishes events in order. Inside a transaction they are staged until CommitTxn.
+// Returns *event.BatchError if some events fail
+func (p *Producer) BatchSend(ctx context.Context, msg []event.Event) error {
+	tx, _ := ctx.Value(txnKey{}).(*txn)
+	errs := make([]error, len(msg))
+	failed := false
+	for i, e := range msg {
+		m := ToMsg(p.opt.subject(e), e)
+		if tx == nil {
+			if _, errs[i] = p.js.PublishMsg(m, publishOpts(ctx, m)...); errs[i] != nil {
+				failed = true
+			}
+			continue
+		}
+		m.Header.Set(HeaderSubject, m.Subject)
+		m.Subject = tx.subject
+		ack, err := p.js.PublishMsg(m, nats.Context(ctx))
+		if err != nil {
+			errs[i] = err
+			failed = true
+			continue
+		}
+		tx.add(ack.Sequence)
+	}
+	if !failed {
+		return nil
+	}
+	return event.NewBatchError(errs)
+}
+
+// publishOpts deduplicates m by the event id if present
+func publishOpts(ctx context.Context, m *nats.Msg) []nats.PubOpt {
+	opts := []nats.PubOpt{nats.Context(ctx)}
+	if id := event.MapHeader(m.Header).Get(event.HeaderId); len(id) > 0 {
+		opts = append(opts, nats.MsgId(id))
+	}
+	return opts
+}
+
+type txnKey struct{}
+
+type txn struct {
+	subject string
+	seqs    []uint64
+	mtx     sync.Mutex
+}
+
+func (t *txn) add(seq uint64) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.seqs = append(t.seqs, seq)
+}
+
+func (p *Producer) BeginTxn(ctx context.Context) (context.Context, error) {
+	tx := &txn{subject: fmt.Sprintf("%s.%s", p.opt.prefix, uuid.New().String())}
+	return context.WithValue(ctx, txnKey{}, tx), nil
+}
+
+// CommitTxn republishes staged events to their subjects in the order they were sent, then purges the staging subject
+func (p *Producer) CommitTxn(ctx context.Context) error {
+	tx, ok := ctx.Value(txnKey{}).(*txn)
+	if !ok {
+		return ErrNoTxn
+	}
+	tx.mtx.Lock()
+	defer tx.mtx.Unlock()
+	for len(tx.seqs) > 0 {
+		raw, err := p.js.GetMsg(p.opt.stream, tx.seqs[0], nats.Context(ctx))
+		if err != nil {
+			return err
+		}
+		m := &nats.Msg{Subject: raw.Header.Get(HeaderSubject), Header: raw.Header, Data: raw.Data}
+		m.Header.Del(HeaderSubject)
+		if _, err = p.js.PublishMsg(m, publishOpts(ctx, m)...); err != nil {
+			return err
+		}
+		//commit can be resumed from the failed event
+		tx.seqs = tx.seqs[1:]
+	}
+	return p.purge(ctx, tx)
+}
+
+// AbortTxn purges staged events
+func (p *Producer) AbortTxn(ctx context.Context) error {
+	tx, ok := ctx.Value(txnKey{}).(*txn)
+	if !ok {
+		return ErrNoTxn
+	}
+	tx.mtx.Lock()
+	defer tx.mtx.Unlock()
+	tx.seqs = nil
+	return p.purge(ctx, tx)
+}
+
+func (p *Producer) purge(ctx context.Context, tx *txn) error {
+	return p.js.PurgeStream(p.opt.stream, &nats.StreamPurgeRequest{Subject: tx.subject}, nats.Context(ctx))
+}
+
+// ToMsg convert event into nats message of subject
+func ToMsg(subject string, e event.Event) *nats.Msg {
+	m := nats.NewMsg(subject)
+	m.Data = e.Value()
+	for k, v := range event.CloneHeader(e.Header()) {
+		m.Header[k] = v
+	}
+	return m
+}
+
+// FromMsg convert nats message into event. Subject is used as key
+func FromMsg(m *nats.Msg) *event.Message {
+	b := event.NewBuilder(m.Subject).Value(m.Data)
+	for k, v := range m.Header {
+		for _, vv := range v {
+			b.Header(k, vv)
+		}
+	}
+	return b.Build()
+}
@@ -0,0 +1,179 @@
+package nats
+
+import (
+	"context"
+	"fmt"
+	"github.com/jace996/uow"
+	"github.com/jace996/uow/event"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func runServer(t *testing.T) nats.JetStreamContext {
+	s, err := server.NewServer(&server.Options{JetStream: true, StoreDir: t.TempDir(), Port: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	t.Cleanup(s.Shutdown)
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = js.AddStream(&nats.StreamConfig{Name: "ORDERS", Subjects: []string{"order.>"}}); err != nil {
+		t.Fatal(err)
+	}
+	return js
+}
+
+func fetch(t *testing.T, js nats.JetStreamContext) []string {
+	info, err := js.StreamInfo("ORDERS")
+	assert.NoError(t, err)
+	var ret []string
+	for seq := info.State.FirstSeq; seq > 0 && seq <= info.State.LastSeq; seq++ {
+		raw, err := js.GetMsg("ORDERS", seq)
+		if assert.NoError(t, err) {
+			ret = append(ret, fmt.Sprintf("%s:%s", raw.Subject, raw.Data))
+		}
+	}
+	return ret
+}
+
+func staged(t *testing.T, js nats.JetStreamContext) uint64 {
+	info, err := js.StreamInfo(DefaultStagingStream)
+	assert.NoError(t, err)
+	return info.State.Msgs
+}
+
+func newManager(p event.Producer) uow.Manager {
+	return uow.NewManager(func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+		return event.NewTransactional(ctx, p), nil
+	})
+}
+
+func TestSend(t *testing.T) {
+	js := runServer(t)
+	p, err := NewProducer(js)
+	assert.NoError(t, err)
+	msg := event.NewMessage("order.created", []byte("1"))
+	assert.NoError(t, p.Send(context.Background(), msg))
+	//deduplicated by event id
+	assert.NoError(t, p.Send(context.Background(), msg))
+	assert.Equal(t, []string{"order.created:1"}, fetch(t, js))
+}
+
+// bareEvent has no header
+type bareEvent struct {
+	key   string
+	value []byte
+}
+
+func (e *bareEvent) Header() event.Header {
+	return nil
+}
+
+func (e *bareEvent) Key() string {
+	return e.key
+}
+
+func (e *bareEvent) Value() []byte {
+	return e.value
+}
+
+func TestSendWithoutHeader(t *testing.T) {
+	js := runServer(t)
+	p, err := NewProducer(js)
+	assert.NoError(t, err)
+	e := &bareEvent{key: "order.created", value: []byte("1")}
+	//not deduplicated without event id
+	assert.NoError(t, p.Send(context.Background(), e))
+	assert.NoError(t, p.Send(context.Background(), e))
+	assert.Equal(t, []string{"order.created:1", "order.created:1"}, fetch(t, js))
+}
+
+func TestTxn(t *testing.T) {
+	js := runServer(t)
+	p, err := NewProducer(js)
+	assert.NoError(t, err)
+	mgr := newManager(p)
+	transP := event.NewTransactionalProducer(p, []string{"event"})
+
+	err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		if err := transP.Send(ctx, event.NewMessage("order.created", []byte("1"))); err != nil {
+			return err
+		}
+		if err := transP.Send(ctx, event.NewMessage("order.paid", []byte("1"))); err != nil {
+			return err
+		}
+		//sent eagerly but invisible before commit
+		assert.Equal(t, uint64(2), staged(t, js))
+		assert.Empty(t, fetch(t, js))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"order.created:1", "order.paid:1"}, fetch(t, js))
+	assert.Equal(t, uint64(0), staged(t, js))
+
+	err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		if err := transP.Send(ctx, event.NewMessage("order.created", []byte("2"))); err != nil {
+			return err
+		}
+		return fmt.Errorf("fake error")
+	})
+	assert.Error(t, err)
+	assert.Len(t, fetch(t, js), 2)
+	assert.Equal(t, uint64(0), staged(t, js))
+}
+
+func TestNestedTxn(t *testing.T) {
+	js := runServer(t)
+	p, err := NewProducer(js)
+	assert.NoError(t, err)
+	mgr := newManager(p)
+	transP := event.NewTransactionalProducer(p, []string{"event"})
+
+	err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		err := mgr.WithNew(ctx, func(ctx context.Context) error {
+			return transP.Send(ctx, event.NewMessage("order.created", []byte("1")))
+		})
+		assert.NoError(t, err)
+		err = mgr.WithNew(ctx, func(ctx context.Context) error {
+			if err := transP.Send(ctx, event.NewMessage("order.created", []byte("2"))); err != nil {
+				return err
+			}
+			return fmt.Errorf("fake error")
+		})
+		assert.Error(t, err)
+		assert.Empty(t, fetch(t, js))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"order.created:1"}, fetch(t, js))
+}
+
+func TestCommitIdempotent(t *testing.T) {
+	js := runServer(t)
+	p, err := NewProducer(js)
+	assert.NoError(t, err)
+	msg := event.NewMessage("order.created", []byte("1"))
+	ctx, err := p.BeginTxn(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, p.Send(ctx, msg))
+	//a previous commit attempt published the event but failed before purging
+	assert.NoError(t, p.Send(context.Background(), msg))
+	assert.NoError(t, p.CommitTxn(ctx))
+	assert.Equal(t, []string{"order.created:1"}, fetch(t, js))
+	assert.Equal(t, uint64(0), staged(t, js))
+	assert.ErrorIs(t, p.CommitTxn(context.Background()), ErrNoTxn)
+}
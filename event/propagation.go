@@ -0,0 +1,178 @@
+package event
+
+import (
+	"context"
+	"github.com/jace996/uow"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+const (
+	// HeaderUowId is the header key of the id of the unit of work which produced an event
+	HeaderUowId = "Uow-Id"
+	// HeaderUowChain is the header key of the ids of outer units of work, from root to parent, separated by comma
+	HeaderUowChain = "Uow-Chain"
+	// HeaderTraceParent is the W3C trace context traceparent header key
+	HeaderTraceParent = "traceparent"
+	// HeaderTraceState is the W3C trace context tracestate header key
+	HeaderTraceState = "tracestate"
+)
+
+// Propagator injects values of context into event headers on the producer side, and extracts them back on the consumer side.
+// Header is compatible with the TextMapCarrier of opentelemetry, so its propagators can be adapted easily
+type Propagator interface {
+	Inject(ctx context.Context, h Header)
+	Extract(ctx context.Context, h Header) context.Context
+}
+
+type propagators []Propagator
+
+// Propagators combines multiple propagators
+func Propagators(p ...Propagator) Propagator {
+	return propagators(p)
+}
+
+func (p propagators) Inject(ctx context.Context, h Header) {
+	for _, pp := range p {
+		pp.Inject(ctx, h)
+	}
+}
+
+func (p propagators) Extract(ctx context.Context, h Header) context.Context {
+	for _, pp := range p {
+		ctx = pp.Extract(ctx, h)
+	}
+	return ctx
+}
+
+// DefaultPropagator propagates unit of work and W3C trace context
+var DefaultPropagator = Propagators(UowPropagator{}, TraceContextPropagator{})
+
+// Origin is the unit of work which produced a received event
+type Origin struct {
+	UowId string
+	// Chain is the ids of outer units of work, from root to parent
+	Chain []string
+}
+
+type originKey struct{}
+
+func NewOriginContext(ctx context.Context, o Origin) context.Context {
+	return context.WithValue(ctx, originKey{}, o)
+}
+
+func OriginFromContext(ctx context.Context) (o Origin, ok bool) {
+	o, ok = ctx.Value(originKey{}).(Origin)
+	return
+}
+
+// UowPropagator propagates the current unit of work id and its parent chain
+type UowPropagator struct{}
+
+func (UowPropagator) Inject(ctx context.Context, h Header) {
+	u, ok := uow.FromCurrentUow(ctx)
+	if !ok {
+		return
+	}
+	h.Set(HeaderUowId, u.GetId())
+	var chain []string
+	for p := u.Parent(); p != nil; p = p.Parent() {
+		chain = append([]string{p.GetId()}, chain...)
+	}
+	if len(chain) > 0 {
+		h.Set(HeaderUowChain, strings.Join(chain, ","))
+	}
+}
+
+func (UowPropagator) Extract(ctx context.Context, h Header) context.Context {
+	id := h.Get(HeaderUowId)
+	if len(id) == 0 {
+		return ctx
+	}
+	o := Origin{UowId: id}
+	if chain := h.Get(HeaderUowChain); len(chain) > 0 {
+		o.Chain = strings.Split(chain, ",")
+	}
+	return NewOriginContext(ctx, o)
+}
+
+// TraceContext is the W3C trace context
+type TraceContext struct {
+	TraceParent string
+	TraceState  string
+}
+
+// Valid reports whether TraceParent is well-formed as "version-traceid-parentid-flags"
+func (t TraceContext) Valid() bool {
+	parts := strings.Split(t.TraceParent, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return false
+	}
+	for _, p := range parts[:4] {
+		for _, c := range p {
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+				return false
+			}
+		}
+	}
+	return parts[0] != "ff" && parts[1] != strings.Repeat("0", 32) && parts[2] != strings.Repeat("0", 16)
+}
+
+type traceContextKey struct{}
+
+func NewTraceContext(ctx context.Context, t TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, t)
+}
+
+func TraceContextFromContext(ctx context.Context) (t TraceContext, ok bool) {
+	t, ok = ctx.Value(traceContextKey{}).(TraceContext)
+	return
+}
+
+// TraceContextPropagator propagates the opentelemetry span of ctx, e.g. started by kratos tracing middleware, as W3C trace context.
+// If ctx has no span, TraceContext stored by NewTraceContext is propagated.
+// Extract makes the trace context both the remote span of opentelemetry and TraceContext of ctx
+type TraceContextPropagator struct{}
+
+var w3c = propagation.TraceContext{}
+
+func (TraceContextPropagator) Inject(ctx context.Context, h Header) {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		w3c.Inject(ctx, h)
+		return
+	}
+	t, ok := TraceContextFromContext(ctx)
+	if !ok || !t.Valid() {
+		return
+	}
+	h.Set(HeaderTraceParent, t.TraceParent)
+	if len(t.TraceState) > 0 {
+		h.Set(HeaderTraceState, t.TraceState)
+	}
+}
+
+func (TraceContextPropagator) Extract(ctx context.Context, h Header) context.Context {
+	t := TraceContext{TraceParent: h.Get(HeaderTraceParent), TraceState: h.Get(HeaderTraceState)}
+	if !t.Valid() {
+		return ctx
+	}
+	return NewTraceContext(w3c.Extract(ctx, h), t)
+}
+
+// ExtractContext restores values carried by headers of e into ctx
+func ExtractContext(ctx context.Context, e Event, p Propagator) context.Context {
+	if h := e.Header(); h != nil {
+		return p.Extract(ctx, h)
+	}
+	return ctx
+}
+
+// Extract restores values carried by event headers into the handler context
+func Extract(p Propagator) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, e Event) error {
+			return next(ExtractContext(ctx, e, p), e)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package event
+
+import (
+	"context"
+	"github.com/jace996/uow"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+)
+
+type captureProducer struct {
+	sent []Event
+}
+
+func (p *captureProducer) Close() error {
+	return nil
+}
+
+func (p *captureProducer) Send(ctx context.Context, msg Event) error {
+	return p.BatchSend(ctx, []Event{msg})
+}
+
+func (p *captureProducer) BatchSend(ctx context.Context, msg []Event) error {
+	p.sent = append(p.sent, msg...)
+	return nil
+}
+
+const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestPropagation(t *testing.T) {
+	p := &captureProducer{}
+	mgr := newRecordManager(p)
+	transP := NewTransactionalProducer(p, []string{"event"}, WithPropagator(DefaultPropagator))
+	ctx := NewTraceContext(context.Background(), TraceContext{TraceParent: traceParent, TraceState: "a=b"})
+
+	var ids []string
+	err := mgr.WithNew(ctx, func(ctx context.Context) error {
+		u, _ := uow.FromCurrentUow(ctx)
+		ids = append(ids, u.GetId())
+		return mgr.WithNew(ctx, func(ctx context.Context) error {
+			u, _ := uow.FromCurrentUow(ctx)
+			ids = append(ids, u.GetId())
+			return mgr.WithNew(ctx, func(ctx context.Context) error {
+				u, _ := uow.FromCurrentUow(ctx)
+				ids = append(ids, u.GetId())
+				return transP.Send(ctx, NewMessage("1", nil))
+			})
+		})
+	})
+	assert.NoError(t, err)
+	assert.Len(t, p.sent, 1)
+	h := p.sent[0].Header()
+	assert.Equal(t, ids[2], h.Get(HeaderUowId))
+	assert.Equal(t, ids[0]+","+ids[1], h.Get(HeaderUowChain))
+	assert.Equal(t, traceParent, h.Get(HeaderTraceParent))
+	assert.Equal(t, "a=b", h.Get(HeaderTraceState))
+
+	//consumer side
+	var restored context.Context
+	handler := ChainHandler(func(ctx context.Context, e Event) error {
+		restored = ctx
+		return nil
+	}, Extract(DefaultPropagator))
+	assert.NoError(t, handler(context.Background(), p.sent[0]))
+	o, ok := OriginFromContext(restored)
+	assert.True(t, ok)
+	assert.Equal(t, Origin{UowId: ids[2], Chain: ids[:2]}, o)
+	tc, ok := TraceContextFromContext(restored)
+	assert.True(t, ok)
+	assert.Equal(t, traceParent, tc.TraceParent)
+	sc := trace.SpanContextFromContext(restored)
+	assert.True(t, sc.IsRemote())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+
+	//direct send without unit of work
+	assert.NoError(t, transP.Send(ctx, NewMessage("2", nil)))
+	assert.Empty(t, p.sent[1].Header().Get(HeaderUowId))
+	assert.Equal(t, traceParent, p.sent[1].Header().Get(HeaderTraceParent))
+}
+
+func TestOtelTraceContext(t *testing.T) {
+	p := &captureProducer{}
+	transP := NewTransactionalProducer(p, nil, WithPropagator(DefaultPropagator))
+	traceId, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanId, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	//span started by opentelemetry instrumentation
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceId,
+		SpanID:     spanId,
+		TraceFlags: trace.FlagsSampled,
+	}))
+	assert.NoError(t, transP.Send(ctx, NewMessage("1", nil)))
+	assert.Equal(t, traceParent, p.sent[0].Header().Get(HeaderTraceParent))
+}
+
+func TestTraceContextValid(t *testing.T) {
+	assert.True(t, TraceContext{TraceParent: traceParent}.Valid())
+	assert.False(t, TraceContext{TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7"}.Valid())
+	assert.False(t, TraceContext{TraceParent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"}.Valid())
+	assert.False(t, TraceContext{TraceParent: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"}.Valid())
+}
@@ -0,0 +1,32 @@
+package event
+
+import (
+	"time"
+)
+
+// Backoff returns how long to wait before the attempt-th (1-based) retry
+type Backoff func(attempt int) time.Duration
+
+// ExponentialBackoff doubles the wait from base on every attempt, capped by max
+func ExponentialBackoff(base, max time.Duration) Backoff {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt; i++ {
+			d *= 2
+			if d >= max || d <= 0 {
+				return max
+			}
+		}
+		if d > max {
+			return max
+		}
+		return d
+	}
+}
+
+// ConstantBackoff always waits d
+func ConstantBackoff(d time.Duration) Backoff {
+	return func(attempt int) time.Duration {
+		return d
+	}
+}
@@ -0,0 +1,59 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	// HeaderDeliverAt is the header key of the time when an event should be delivered, formatted as RFC3339 with nanoseconds
+	HeaderDeliverAt = "Event-Deliver-At"
+)
+
+var ErrNoScheduler = errors.New("event: no scheduler")
+
+// DeliverAt returns the deliver-at time of e. false if absent or malformed
+func DeliverAt(e Event) (time.Time, bool) {
+	h := e.Header()
+	if h == nil {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, h.Get(HeaderDeliverAt))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// Scheduler persists events until their deliver-at time, e.g. outbox.Producer.
+// Inside a unit of work, both Schedule and Cancel should take effect only when it commits
+type Scheduler interface {
+	Schedule(ctx context.Context, events []Event) error
+	// Cancel removes scheduled events by id which are not delivered yet
+	Cancel(ctx context.Context, ids ...string) error
+}
+
+// scheduleTo sends events due in the future to s and others to next
+func scheduleTo(s Scheduler, next SendFunc) SendFunc {
+	return func(ctx context.Context, msg []Event) error {
+		now := time.Now()
+		var due, delayed []Event
+		for _, e := range msg {
+			if at, ok := DeliverAt(e); ok && at.After(now) {
+				delayed = append(delayed, e)
+			} else {
+				due = append(due, e)
+			}
+		}
+		if len(delayed) > 0 {
+			if err := s.Schedule(ctx, delayed); err != nil {
+				return err
+			}
+		}
+		if len(due) == 0 {
+			return nil
+		}
+		return next(ctx, due)
+	}
+}
@@ -0,0 +1,69 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Sink stores events which can not be delivered by Producer, so they can be recovered later
+type Sink interface {
+	Store(ctx context.Context, events []Event, cause error) error
+}
+
+// SinkFunc adapts a function to Sink
+type SinkFunc func(ctx context.Context, events []Event, cause error) error
+
+func (f SinkFunc) Store(ctx context.Context, events []Event, cause error) error {
+	return f(ctx, events, cause)
+}
+
+// FileSink appends events as json lines into a file
+type FileSink struct {
+	path string
+	mtx  sync.Mutex
+}
+
+var _ Sink = (*FileSink)(nil)
+
+// SpooledEvent is a line written by FileSink
+type SpooledEvent struct {
+	Key     string    `json:"key"`
+	Value   []byte    `json:"value"`
+	Headers MapHeader `json:"headers,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+func (f *FileSink) Store(ctx context.Context, events []Event, cause error) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	now := time.Now()
+	enc := json.NewEncoder(file)
+	for _, e := range events {
+		line := &SpooledEvent{
+			Key:     e.Key(),
+			Value:   e.Value(),
+			Headers: CloneHeader(e.Header()),
+			Time:    now,
+		}
+		if cause != nil {
+			line.Error = cause.Error()
+		}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+	return file.Sync()
+}
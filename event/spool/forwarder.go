@@ -0,0 +1,111 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"github.com/jace996/uow/event"
+	"github.com/jace996/uow/internal/loop"
+	"time"
+)
+
+var ErrForwarderStarted = errors.New("spool: forwarder already started")
+
+type options struct {
+	batchSize  int
+	interval   time.Duration
+	errHandler func(err error)
+}
+
+type Option func(*options)
+
+// WithBatchSize change the max number of events sent in one BatchSend. default 100
+func WithBatchSize(n int) Option {
+	return func(o *options) {
+		o.batchSize = n
+	}
+}
+
+// WithInterval change the polling and retry interval. default 1s
+func WithInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.interval = d
+	}
+}
+
+// WithErrorHandler handle errors of the forward loop. default ignore
+func WithErrorHandler(f func(err error)) Option {
+	return func(o *options) {
+		o.errHandler = f
+	}
+}
+
+// Forwarder drains a Spool into a downstream event.Producer with at-least-once delivery.
+// The checkpoint is saved after each delivered batch, so events are resent only if the process crashes in between
+type Forwarder struct {
+	spool    *Spool
+	producer event.Producer
+	opt      *options
+
+	loop *loop.Loop
+}
+
+func NewForwarder(spool *Spool, producer event.Producer, opts ...Option) *Forwarder {
+	opt := &options{
+		batchSize:  100,
+		interval:   time.Second,
+		errHandler: func(err error) {},
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	f := &Forwarder{
+		spool:    spool,
+		producer: producer,
+		opt:      opt,
+	}
+	f.loop = loop.New(loop.Config{
+		Run:        f.ForwardOnce,
+		BatchSize:  opt.batchSize,
+		Interval:   opt.interval,
+		Notify:     spool.notify,
+		ErrHandler: opt.errHandler,
+		ErrStarted: ErrForwarderStarted,
+	})
+	return f
+}
+
+// Start runs the forward loop and blocks until Stop is called or ctx is done, so Forwarder can be used as a kratos transport.Server
+func (f *Forwarder) Start(ctx context.Context) error {
+	return f.loop.Start(ctx)
+}
+
+// Stop the forward loop gracefully. The in-flight batch is finished unless ctx is done first
+func (f *Forwarder) Stop(ctx context.Context) error {
+	return f.loop.Stop(ctx)
+}
+
+// ForwardOnce sends one batch of spooled events and moves the checkpoint after the delivered ones.
+// Returns the number of delivered events
+func (f *Forwarder) ForwardOnce(ctx context.Context) (int, error) {
+	events, ends, err := f.spool.read(f.opt.batchSize)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+	sendErr := f.producer.BatchSend(ctx, events)
+	delivered := len(events)
+	var be *event.BatchError
+	if errors.As(sendErr, &be) && len(be.Errs) == len(events) {
+		//checkpoint can only move to the first failed event
+		if failed := be.Failed(); len(failed) > 0 {
+			delivered = failed[0]
+		}
+	} else if sendErr != nil {
+		delivered = 0
+	}
+	if delivered > 0 {
+		if err := f.spool.commit(ends[delivered-1]); err != nil {
+			return 0, errors.Join(sendErr, err)
+		}
+	}
+	return delivered, sendErr
+}
@@ -0,0 +1,386 @@
+package spool
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jace996/uow/event"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentPrefix  = "events-"
+	segmentSuffix  = ".wal"
+	checkpointFile = "checkpoint"
+	// headerSize is the length and crc32 of a record
+	headerSize = 8
+)
+
+var ErrClosed = errors.New("spool: closed")
+
+type spoolOptions struct {
+	segmentSize int64
+}
+
+type SpoolOption func(*spoolOptions)
+
+// WithSegmentSize change the size after which a new log segment is started. default 64MB
+func WithSegmentSize(n int64) SpoolOption {
+	return func(o *spoolOptions) {
+		o.segmentSize = n
+	}
+}
+
+// segment is a log file holding records from the global offset base
+type segment struct {
+	base int64
+	file *os.File
+	size int64
+}
+
+func (g *segment) end() int64 {
+	return g.base + g.size
+}
+
+// Spool is a Producer appending events into an on-disk write-ahead log. BatchSend returns after events are fsynced,
+// so used as the producer of event.Transactional, events of a unit of work are durable when it commits.
+// Events are shipped to the real broker by a Forwarder. The log is split into segments,
+// which are deleted once forwarded, so it stays bounded while writes keep coming. A spool directory must be used by one process only
+type Spool struct {
+	dir        string
+	opt        *spoolOptions
+	mtx        sync.Mutex
+	segments   []*segment
+	checkpoint int64
+	notify     chan struct{}
+	closed     bool
+	// broken is set if a failed write can not be dropped from the log. Forwarding still works, writing does not
+	broken error
+}
+
+var _ event.Producer = (*Spool)(nil)
+
+// Open the spool in dir, creating it if absent. A partially written record at the end of the log, e.g. by a crash, is discarded
+func Open(dir string, opts ...SpoolOption) (*Spool, error) {
+	opt := &spoolOptions{segmentSize: 64 << 20}
+	for _, o := range opts {
+		o(opt)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Spool{dir: dir, opt: opt, notify: make(chan struct{}, 1)}
+	if err := s.recover(); err != nil {
+		s.closeSegments()
+		return nil, err
+	}
+	return s, nil
+}
+
+func segmentName(base int64) string {
+	return fmt.Sprintf("%s%020d%s", segmentPrefix, base, segmentSuffix)
+}
+
+func (s *Spool) openSegment(base int64) (*segment, error) {
+	f, err := os.OpenFile(filepath.Join(s.dir, segmentName(base)), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &segment{base: base, file: f, size: info.Size()}, nil
+}
+
+func (s *Spool) recover() error {
+	b, err := os.ReadFile(filepath.Join(s.dir, checkpointFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(b) > 0 {
+		if s.checkpoint, err = strconv.ParseInt(string(b), 10, 64); err != nil {
+			return err
+		}
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	var bases []int64
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		base, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		bases = append(bases, base)
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+	for _, base := range bases {
+		g, err := s.openSegment(base)
+		if err != nil {
+			return err
+		}
+		s.segments = append(s.segments, g)
+	}
+	if len(s.segments) == 0 {
+		//continue offsets after the checkpoint
+		g, err := s.openSegment(s.checkpoint)
+		if err != nil {
+			return err
+		}
+		s.segments = append(s.segments, g)
+		return syncDir(s.dir)
+	}
+
+	//find the end of the last complete record
+	last := s.segments[len(s.segments)-1]
+	var end int64
+	for {
+		_, next, err := readAt(last.file, end, last.size)
+		if err != nil {
+			break
+		}
+		end = next
+	}
+	if last.size > end {
+		if err := last.file.Truncate(end); err != nil {
+			return err
+		}
+		if err := last.file.Sync(); err != nil {
+			return err
+		}
+		last.size = end
+	}
+	if first := s.segments[0].base; s.checkpoint < first {
+		s.checkpoint = first
+	}
+	//crashed before forwarded segments were deleted
+	return s.compact()
+}
+
+func (s *Spool) closeSegments() {
+	for _, g := range s.segments {
+		g.file.Close()
+	}
+}
+
+func (s *Spool) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	var errs []error
+	for _, g := range s.segments {
+		errs = append(errs, g.file.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Spool) Send(ctx context.Context, msg event.Event) error {
+	return s.BatchSend(ctx, []event.Event{msg})
+}
+
+// BatchSend appends events and fsync the log
+func (s *Spool) BatchSend(ctx context.Context, msg []event.Event) error {
+	var buf bytes.Buffer
+	now := time.Now()
+	for _, e := range msg {
+		payload, err := json.Marshal(&event.SpooledEvent{
+			Key:     e.Key(),
+			Value:   e.Value(),
+			Headers: event.CloneHeader(e.Header()),
+			Time:    now,
+		})
+		if err != nil {
+			return err
+		}
+		var header [headerSize]byte
+		binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+		binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(payload))
+		buf.Write(header[:])
+		buf.Write(payload)
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.broken != nil {
+		return s.broken
+	}
+	active := s.segments[len(s.segments)-1]
+	if active.size >= s.opt.segmentSize {
+		g, err := s.roll()
+		if err != nil {
+			return err
+		}
+		active = g
+	}
+	n, err := active.file.Write(buf.Bytes())
+	if err == nil {
+		err = active.file.Sync()
+	}
+	if err != nil {
+		//drop partial or not synced records, so later records are written at the tracked size
+		if terr := active.file.Truncate(active.size); terr != nil {
+			s.broken = fmt.Errorf("spool: log is corrupted by a failed write: %w", terr)
+		}
+		return err
+	}
+	active.size += int64(n)
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Pending returns the number of bytes not forwarded yet
+func (s *Spool) Pending() int64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.segments[len(s.segments)-1].end() - s.checkpoint
+}
+
+// read at most limit events after checkpoint. Returns events and the offset after each of them
+func (s *Spool) read(limit int) ([]event.Event, []int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return nil, nil, ErrClosed
+	}
+	var events []event.Event
+	var ends []int64
+	off := s.checkpoint
+	for _, g := range s.segments {
+		for off >= g.base && off < g.end() && len(events) < limit {
+			rec, next, err := readAt(g.file, off-g.base, g.size)
+			if err != nil {
+				return nil, nil, err
+			}
+			events = append(events, event.NewBuilder(rec.Key).Value(rec.Value).Headers(rec.Headers).Build())
+			off = g.base + next
+			ends = append(ends, off)
+		}
+	}
+	return events, ends, nil
+}
+
+// readAt reads the record at off of f, which must end before size
+func readAt(f *os.File, off, size int64) (*event.SpooledEvent, int64, error) {
+	var header [headerSize]byte
+	if _, err := f.ReadAt(header[:], off); err != nil {
+		return nil, 0, err
+	}
+	n := int64(binary.BigEndian.Uint32(header[:4]))
+	if off+headerSize+n > size {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	payload := make([]byte, n)
+	if _, err := f.ReadAt(payload, off+headerSize); err != nil {
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	rec := &event.SpooledEvent{}
+	if err := json.Unmarshal(payload, rec); err != nil {
+		return nil, 0, err
+	}
+	return rec, off + headerSize + int64(len(payload)), nil
+}
+
+// roll starts a new active segment after the current one
+func (s *Spool) roll() (*segment, error) {
+	g, err := s.openSegment(s.segments[len(s.segments)-1].end())
+	if err != nil {
+		return nil, err
+	}
+	if err := syncDir(s.dir); err != nil {
+		g.file.Close()
+		return nil, err
+	}
+	s.segments = append(s.segments, g)
+	return g, nil
+}
+
+// commit moves the checkpoint to off, then deletes segments which are fully forwarded.
+// The checkpoint is saved first, so a crash in between only leaves segments to delete on Open
+func (s *Spool) commit(off int64) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if err := writeFile(filepath.Join(s.dir, checkpointFile), []byte(strconv.FormatInt(off, 10))); err != nil {
+		return err
+	}
+	s.checkpoint = off
+	if active := s.segments[len(s.segments)-1]; off == active.end() && active.size > 0 {
+		//everything is forwarded, start an empty segment so the active one can be deleted
+		if _, err := s.roll(); err != nil {
+			return err
+		}
+	}
+	return s.compact()
+}
+
+// compact deletes segments before the checkpoint except the active one
+func (s *Spool) compact() error {
+	for len(s.segments) > 1 && s.segments[0].end() <= s.checkpoint {
+		g := s.segments[0]
+		if err := os.Remove(g.file.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		g.file.Close()
+		s.segments = s.segments[1:]
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// writeFile replaces path atomically
+func writeFile(path string, b []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,159 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"github.com/jace996/uow"
+	"github.com/jace996/uow/event"
+	"github.com/jace996/uow/event/memory"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSpoolSurvivesCrash(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	assert.NoError(t, err)
+	mgr := uow.NewManager(func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+		return event.NewTransactional(ctx, s), nil
+	})
+	transP := event.NewTransactionalProducer(s, []string{"event"})
+	msg := event.NewMessage("order.created", []byte("1"))
+	err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		if err := transP.Send(ctx, msg); err != nil {
+			return err
+		}
+		return transP.Send(ctx, event.NewMessage("order.paid", []byte("1")))
+	})
+	assert.NoError(t, err)
+	//process crashes before forwarding, leaving a torn record
+	assert.NoError(t, s.Close())
+	f, err := os.OpenFile(filepath.Join(dir, segmentName(0)), os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 100, 1, 2})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	s, err = Open(dir)
+	assert.NoError(t, err)
+	defer s.Close()
+	b := memory.NewBroker()
+	defer b.Close()
+	n, err := NewForwarder(s, b).ForwardOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"order.created", "order.paid"}, b.PublishedKeys())
+	assert.Equal(t, msg.Id(), b.Published()[0].Header().Get(event.HeaderId))
+	assert.Equal(t, int64(0), s.Pending())
+}
+
+func TestSpoolSegments(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, WithSegmentSize(200))
+	assert.NoError(t, err)
+	b := memory.NewBroker()
+	defer b.Close()
+	f := NewForwarder(s, b, WithBatchSize(3))
+	segments := func() int {
+		files, err := filepath.Glob(filepath.Join(dir, segmentPrefix+"*"))
+		assert.NoError(t, err)
+		return len(files)
+	}
+	logSize := func() int64 {
+		var ret int64
+		files, _ := filepath.Glob(filepath.Join(dir, segmentPrefix+"*"))
+		for _, f := range files {
+			info, err := os.Stat(f)
+			assert.NoError(t, err)
+			ret += info.Size()
+		}
+		return ret
+	}
+	//writes keep coming while forwarding, the log is never fully drained
+	assert.NoError(t, s.Send(context.Background(), event.NewMessage("0", nil)))
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, s.BatchSend(context.Background(), []event.Event{event.NewMessage("a", nil), event.NewMessage("b", nil), event.NewMessage("c", nil)}))
+		_, err := f.ForwardOnce(context.Background())
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, segments(), 3)
+		assert.Less(t, logSize(), int64(1000))
+	}
+	assert.Equal(t, 60, len(b.Published()))
+	assert.NoError(t, s.Close())
+
+	//resumes from the checkpoint after restart
+	s, err = Open(dir, WithSegmentSize(200))
+	assert.NoError(t, err)
+	defer s.Close()
+	n, err := NewForwarder(s, b, WithBatchSize(100)).ForwardOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 61, len(b.Published()))
+	assert.Equal(t, int64(0), s.Pending())
+	assert.Equal(t, 1, segments())
+}
+
+// failProducer fails the event at index fail of every batch
+type failProducer struct {
+	fail int
+}
+
+func (p *failProducer) Close() error {
+	return nil
+}
+
+func (p *failProducer) Send(ctx context.Context, msg event.Event) error {
+	return p.BatchSend(ctx, []event.Event{msg})
+}
+
+func (p *failProducer) BatchSend(ctx context.Context, msg []event.Event) error {
+	errs := make([]error, len(msg))
+	errs[p.fail] = errors.New("broker down")
+	return event.NewBatchError(errs)
+}
+
+func TestForwardPartialFailure(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, s.BatchSend(context.Background(), []event.Event{
+		event.NewMessage("1", nil),
+		event.NewMessage("2", nil),
+		event.NewMessage("3", nil),
+	}))
+	n, err := NewForwarder(s, &failProducer{fail: 1}).ForwardOnce(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 1, n)
+	assert.NoError(t, s.Close())
+
+	//checkpoint survives restart
+	s, err = Open(dir)
+	assert.NoError(t, err)
+	defer s.Close()
+	b := memory.NewBroker()
+	defer b.Close()
+	n, err = NewForwarder(s, b).ForwardOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"2", "3"}, b.PublishedKeys())
+}
+
+func TestForwarderStart(t *testing.T) {
+	s, err := Open(t.TempDir())
+	assert.NoError(t, err)
+	defer s.Close()
+	b := memory.NewBroker()
+	defer b.Close()
+	fw := NewForwarder(s, b, WithInterval(time.Hour))
+	go fw.Start(context.Background())
+
+	assert.NoError(t, s.Send(context.Background(), event.NewMessage("order.created", nil)))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = b.WaitFor(ctx, "order.created")
+	assert.NoError(t, err)
+	assert.NoError(t, fw.Stop(ctx))
+}
@@ -0,0 +1,79 @@
+package event
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jace996/uow"
+	"io"
+)
+
+// Handler handles a received event
+type Handler func(ctx context.Context, e Event) error
+
+// Middleware wraps a Handler
+type Middleware func(Handler) Handler
+
+// ChainHandler wraps h with middlewares. The first middleware is the outermost
+func ChainHandler(h Handler, m ...Middleware) Handler {
+	for i := len(m) - 1; i >= 0; i-- {
+		h = m[i](h)
+	}
+	return h
+}
+
+// Subscriber receives events of topic and dispatches them to a Handler.
+// Implementations must acknowledge an event only after the handler returns nil, otherwise negatively acknowledge it for redelivery. See Dispatch
+type Subscriber interface {
+	io.Closer
+	Subscribe(ctx context.Context, topic string, h Handler) error
+}
+
+// Acknowledger is implemented by received events which support acknowledgement
+type Acknowledger interface {
+	Ack() error
+	Nack(err error) error
+}
+
+// Dispatch run h with e, then acknowledge e if h succeeds or negatively acknowledge it if h fails
+func Dispatch(ctx context.Context, e Event, h Handler) error {
+	err := h(ctx, e)
+	a, ok := e.(Acknowledger)
+	if !ok {
+		return err
+	}
+	if err != nil {
+		if nerr := a.Nack(err); nerr != nil {
+			return errors.Join(err, nerr)
+		}
+		return err
+	}
+	return a.Ack()
+}
+
+// Uow handle each event in a new unit of work. The handler returns after the unit of work commits,
+// so the event is acknowledged only after commit, and events sent by TransactionalProducer inside the handler are committed together
+func Uow(mgr uow.Manager, opt ...*sql.TxOptions) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, e Event) error {
+			return mgr.WithNew(ctx, func(ctx context.Context) error {
+				return next(ctx, e)
+			}, opt...)
+		}
+	}
+}
+
+// Recover converts panics of handler into errors, so the event is negatively acknowledged instead of crashing the subscriber
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, e Event) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("event: handler panic: %v", r)
+				}
+			}()
+			return next(ctx, e)
+		}
+	}
+}
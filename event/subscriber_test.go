@@ -0,0 +1,73 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type ackMessage struct {
+	Event
+	acked  bool
+	nacked error
+}
+
+func (a *ackMessage) Ack() error {
+	a.acked = true
+	return nil
+}
+
+func (a *ackMessage) Nack(err error) error {
+	a.nacked = err
+	return nil
+}
+
+func TestSubscriberUow(t *testing.T) {
+	p := &recordProducer{}
+	mgr := newRecordManager(p)
+	transP := NewTransactionalProducer(p, []string{"event"})
+	handlerErr := errors.New("handler error")
+
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, e Event) error {
+				order = append(order, name)
+				return next(ctx, e)
+			}
+		}
+	}
+	h := ChainHandler(func(ctx context.Context, e Event) error {
+		//publish follow-up event in the same unit of work
+		if err := transP.Send(ctx, NewMessage(e.Key()+".handled", nil)); err != nil {
+			return err
+		}
+		if string(e.Value()) == "fail" {
+			return handlerErr
+		}
+		if string(e.Value()) == "panic" {
+			panic("boom")
+		}
+		return nil
+	}, trace("first"), Uow(mgr), Recover(), trace("second"))
+
+	ok := &ackMessage{Event: NewMessage("ok", nil)}
+	assert.NoError(t, Dispatch(context.Background(), ok, h))
+	assert.True(t, ok.acked)
+	assert.Equal(t, [][]string{{"ok.handled"}}, p.sent)
+	assert.Equal(t, []string{"first", "second"}, order)
+
+	fail := &ackMessage{Event: NewMessage("fail", []byte("fail"))}
+	assert.ErrorIs(t, Dispatch(context.Background(), fail, h), handlerErr)
+	assert.False(t, fail.acked)
+	assert.ErrorIs(t, fail.nacked, handlerErr)
+
+	panicked := &ackMessage{Event: NewMessage("panic", []byte("panic"))}
+	assert.Error(t, Dispatch(context.Background(), panicked, h))
+	assert.False(t, panicked.acked)
+	assert.Error(t, panicked.nacked)
+
+	//follow-up events of failed handlers are rolled back
+	assert.Equal(t, [][]string{{"ok.handled"}}, p.sent)
+}
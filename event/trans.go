@@ -3,59 +3,320 @@ package event
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"github.com/jace996/uow"
 	"sync"
+	"time"
 )
 
+// LostHandler is called with events which can not be delivered nor stored by fallback Sink
+type LostHandler func(ctx context.Context, events []Event, err error)
+
+type transactionalOptions struct {
+	detach      bool
+	sendTimeout time.Duration
+	retries     int
+	backoff     Backoff
+	fallback    Sink
+	lost        LostHandler
+	maxSize     int
+	maxBytes    int
+	dedup       bool
+	merge       Merge
+}
+
+type TransactionalOption func(*transactionalOptions)
+
+// WithDetachedContext send events with a context detached from the cancellation of the creating context,
+// so a finished request does not drop events of a committed unit of work. timeout limits the whole delivery, 0 means no limit
+func WithDetachedContext(timeout time.Duration) TransactionalOption {
+	return func(o *transactionalOptions) {
+		o.detach = true
+		o.sendTimeout = timeout
+	}
+}
+
+// WithRetry retry BatchSend at most n times waiting by backoff
+func WithRetry(n int, backoff Backoff) TransactionalOption {
+	return func(o *transactionalOptions) {
+		o.retries = n
+		o.backoff = backoff
+	}
+}
+
+// WithMaxBatch split buffered events into BatchSend calls of at most size events and bytes bytes. 0 means no limit
+func WithMaxBatch(size, bytes int) TransactionalOption {
+	return func(o *transactionalOptions) {
+		o.maxSize = size
+		o.maxBytes = bytes
+	}
+}
+
+// WithDedup drop exact duplicates of buffered events before sending. See Dedup.
+// Not applied if the producer is a TxProducer, which sends events eagerly
+func WithDedup() TransactionalOption {
+	return func(o *transactionalOptions) {
+		o.dedup = true
+	}
+}
+
+// WithCoalesce merge buffered events of the same key before sending, e.g. KeepLast. See Coalesce.
+// Not applied if the producer is a TxProducer, which sends events eagerly
+func WithCoalesce(merge Merge) TransactionalOption {
+	return func(o *transactionalOptions) {
+		o.merge = merge
+	}
+}
+
+// WithFallback store events into sink if they still fail after retries
+func WithFallback(sink Sink) TransactionalOption {
+	return func(o *transactionalOptions) {
+		o.fallback = sink
+	}
+}
+
+// WithLostHandler called with events which are lost, e.g. to alert
+func WithLostHandler(h LostHandler) TransactionalOption {
+	return func(o *transactionalOptions) {
+		o.lost = h
+	}
+}
+
 type Transactional struct {
 	ctx      context.Context
 	producer Producer
+	opt      *transactionalOptions
 	events   []Event
+	// parent is the Transactional of outer unit of work. nil for root
+	parent *Transactional
+	// txn is true if this is a transaction began by Begin
+	txn bool
+	// txCtx is the context of broker-native transaction if producer is a TxProducer. Only set on root
+	txCtx context.Context
 	sync.Mutex
 }
 
-func NewTransactional(ctx context.Context, producer Producer) *Transactional {
+func NewTransactional(ctx context.Context, producer Producer, opts ...TransactionalOption) *Transactional {
+	opt := &transactionalOptions{}
+	for _, o := range opts {
+		o(opt)
+	}
+	return newTransactional(ctx, producer, opt)
+}
+
+func newTransactional(ctx context.Context, producer Producer, opt *transactionalOptions) *Transactional {
 	return &Transactional{
 		ctx:      ctx,
 		producer: producer,
+		opt:      opt,
 	}
 }
 
 var (
 	_ uow.TransactionalDb = (*Transactional)(nil)
 	_ uow.Txn             = (*Transactional)(nil)
+	_ uow.LastCommitter   = (*Transactional)(nil)
 )
 
+// CommitLast returns true, so events are sent after other transactions of the unit of work are committed
+func (t *Transactional) CommitLast() bool {
+	return true
+}
+
 func (t *Transactional) Commit() error {
-	if len(t.events) == 0 {
+	if t.txCtx != nil {
+		//events are already sent, make them visible
+		return t.commitTxn()
+	}
+	t.Lock()
+	events := t.events
+	t.events = nil
+	t.Unlock()
+	if len(events) == 0 {
+		return nil
+	}
+	if t.parent != nil {
+		//merge into outer unit of work, send when root commits
+		return t.parent.Send(events...)
+	}
+	if t.opt.dedup {
+		events = Dedup(events)
+	}
+	if t.opt.merge != nil {
+		events = Coalesce(events, t.opt.merge)
+	}
+	return t.deliver(events)
+}
+
+// deliveryContext returns the context to send with by the delivery policy
+func (t *Transactional) deliveryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if !t.opt.detach {
+		return ctx, func() {}
+	}
+	ctx = context.WithoutCancel(ctx)
+	if t.opt.sendTimeout > 0 {
+		return context.WithTimeout(ctx, t.opt.sendTimeout)
+	}
+	return ctx, func() {}
+}
+
+// commitTxn commits the broker-native transaction, retried by the delivery policy
+func (t *Transactional) commitTxn() error {
+	ctx, cancel := t.deliveryContext(t.txCtx)
+	defer cancel()
+	tp := t.producer.(TxProducer)
+	err := tp.CommitTxn(ctx)
+	for attempt := 1; err != nil && attempt <= t.opt.retries; attempt++ {
+		if werr := wait(ctx, t.opt.backoff, attempt); werr != nil {
+			return errors.Join(err, werr)
+		}
+		err = tp.CommitTxn(ctx)
+	}
+	return err
+}
+
+// deliver events by the delivery policy
+func (t *Transactional) deliver(events []Event) error {
+	ctx, cancel := t.deliveryContext(t.ctx)
+	defer cancel()
+	pending, err := t.sendChunks(ctx, events)
+	for attempt := 1; len(pending) > 0 && attempt <= t.opt.retries; attempt++ {
+		if werr := wait(ctx, t.opt.backoff, attempt); werr != nil {
+			err = errors.Join(err, werr)
+			break
+		}
+		//only re-send failed events
+		pending, err = t.sendChunks(ctx, pending)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if t.opt.fallback != nil {
+		ferr := t.opt.fallback.Store(ctx, pending, err)
+		if ferr == nil {
+			return nil
+		}
+		err = errors.Join(err, ferr)
+	}
+	if t.opt.lost != nil {
+		t.opt.lost(ctx, pending, err)
+	}
+	return err
+}
+
+// sendChunks returns failed events
+func (t *Transactional) sendChunks(ctx context.Context, events []Event) ([]Event, error) {
+	var failed []Event
+	var errs []error
+	for _, chunk := range Split(events, t.opt.maxSize, t.opt.maxBytes) {
+		err := t.producer.BatchSend(ctx, chunk)
+		if f := FailedEvents(chunk, err); len(f) > 0 {
+			failed = append(failed, f...)
+			errs = append(errs, err)
+		}
+	}
+	return failed, errors.Join(errs...)
+}
+
+func wait(ctx context.Context, backoff Backoff, attempt int) error {
+	if backoff == nil {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(backoff(attempt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
 		return nil
 	}
-	return t.producer.BatchSend(t.ctx, t.events)
 }
 
 func (t *Transactional) Rollback() error {
-	//can not perform rollback
+	if t.txCtx != nil {
+		return t.producer.(TxProducer).AbortTxn(t.txCtx)
+	}
+	//discard buffered events
+	t.Lock()
+	defer t.Unlock()
+	t.events = nil
 	return nil
 }
 
 func (t *Transactional) Begin(opt ...*sql.TxOptions) (db uow.Txn, err error) {
-	return NewTransactional(t.ctx, t.producer), nil
+	ret := newTransactional(t.ctx, t.producer, t.opt)
+	ret.txn = true
+	if t.txn {
+		//nested unit of work
+		ret.parent = t
+	} else if tp, ok := t.producer.(TxProducer); ok {
+		//root unit of work uses broker-native transaction
+		if ret.txCtx, err = tp.BeginTxn(t.ctx); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
 }
 
 func (t *Transactional) Send(msg ...Event) error {
+	if t.txCtx != nil {
+		//send eagerly, visible after commit
+		return t.producer.BatchSend(t.txCtx, msg)
+	}
 	t.Lock()
 	defer t.Unlock()
 	t.events = append(t.events, msg...)
 	return nil
 }
 
+func (t *Transactional) send(ctx context.Context, msg []Event) error {
+	return t.Send(msg...)
+}
+
+type producerOptions struct {
+	propagator   Propagator
+	interceptors []Interceptor
+	scheduler    Scheduler
+}
+
+type ProducerOption func(*producerOptions)
+
+// WithPropagator inject values of context into headers of every sent event, e.g. DefaultPropagator
+func WithPropagator(p Propagator) ProducerOption {
+	return func(o *producerOptions) {
+		o.propagator = p
+	}
+}
+
+// WithInterceptors apply interceptors to every sent event, both buffered in unit of work and sent directly.
+// Inside a unit of work they run when events are sent into the buffer, not again when the buffer is flushed,
+// so they do not see rollbacks nor delivery errors. Use Chain on the producer of Transactional to intercept the delivery
+func WithInterceptors(i ...Interceptor) ProducerOption {
+	return func(o *producerOptions) {
+		o.interceptors = append(o.interceptors, i...)
+	}
+}
+
+// WithScheduler hand events with deliver-at time in the future to s instead of sending them.
+// Inside a unit of work s is called with the unit of work context, so a transactional Scheduler stores them atomically
+func WithScheduler(s Scheduler) ProducerOption {
+	return func(o *producerOptions) {
+		o.scheduler = s
+	}
+}
+
 type TransactionalProducer struct {
 	wrap Producer
 	keys []string
+	opt  *producerOptions
 }
 
-func NewTransactionalProducer(wrap Producer, keys []string) *TransactionalProducer {
-	return &TransactionalProducer{wrap: wrap, keys: keys}
+func NewTransactionalProducer(wrap Producer, keys []string, opts ...ProducerOption) *TransactionalProducer {
+	opt := &producerOptions{}
+	for _, o := range opts {
+		o(opt)
+	}
+	return &TransactionalProducer{wrap: wrap, keys: keys, opt: opt}
 }
 
 func (t *TransactionalProducer) Close() error {
@@ -63,29 +324,71 @@ func (t *TransactionalProducer) Close() error {
 }
 
 func (t *TransactionalProducer) Send(ctx context.Context, msg Event) error {
+	t.inject(ctx, msg)
 	if u, ok := uow.FromCurrentUow(ctx); ok {
 		//resolve Transactional from unit of work
-		tx, err := u.GetTxDb(ctx, t.keys...)
+		tx, err := t.resolve(ctx, u)
 		if err != nil {
 			return err
 		}
-		return tx.(*Transactional).Send(msg)
+		return chainInterceptors(t.schedule(tx.send), t.opt.interceptors...)(ctx, []Event{msg})
 	} else {
-		return t.wrap.Send(ctx, msg)
+		return chainInterceptors(t.schedule(sendTo(t.wrap)), t.opt.interceptors...)(ctx, []Event{msg})
 	}
 }
 
 func (t *TransactionalProducer) BatchSend(ctx context.Context, msg []Event) error {
+	t.inject(ctx, msg...)
 	if u, ok := uow.FromCurrentUow(ctx); ok {
 		//resolve Transactional from unit of work
-		tx, err := u.GetTxDb(ctx, t.keys...)
+		tx, err := t.resolve(ctx, u)
 		if err != nil {
 			return err
 		}
-		return tx.(*Transactional).Send(msg...)
+		return chainInterceptors(t.schedule(tx.send), t.opt.interceptors...)(ctx, msg)
 	} else {
-		return t.wrap.BatchSend(ctx, msg)
+		return chainInterceptors(t.schedule(batchSendTo(t.wrap)), t.opt.interceptors...)(ctx, msg)
+	}
+}
+
+// Cancel scheduled events by id. Inside a unit of work they are removed when it commits
+func (t *TransactionalProducer) Cancel(ctx context.Context, ids ...string) error {
+	if t.opt.scheduler == nil {
+		return ErrNoScheduler
+	}
+	return t.opt.scheduler.Cancel(ctx, ids...)
+}
+
+func (t *TransactionalProducer) schedule(next SendFunc) SendFunc {
+	if t.opt.scheduler == nil {
+		return next
+	}
+	return scheduleTo(t.opt.scheduler, next)
+}
+
+func (t *TransactionalProducer) inject(ctx context.Context, msg ...Event) {
+	if t.opt.propagator == nil {
+		return
+	}
+	for _, m := range msg {
+		if h := m.Header(); h != nil {
+			t.opt.propagator.Inject(ctx, h)
+		}
+	}
+}
+
+// resolve Transactional of u. Outer units of work are resolved first, so events of nested unit of work are merged up to root
+func (t *TransactionalProducer) resolve(ctx context.Context, u *uow.UnitOfWork) (*Transactional, error) {
+	if p := u.Parent(); p != nil {
+		if _, err := t.resolve(ctx, p); err != nil {
+			return nil, err
+		}
+	}
+	tx, err := u.GetTxDb(ctx, t.keys...)
+	if err != nil {
+		return nil, err
 	}
+	return tx.(*Transactional), nil
 }
 
 var _ Producer = (*TransactionalProducer)(nil)
@@ -1,65 +1,18 @@
 package event
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/jace996/uow"
 	"github.com/stretchr/testify/assert"
-	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
-type headerCarrier http.Header
-
-// Get returns the value associated with the passed key.
-func (hc headerCarrier) Get(key string) string {
-	return http.Header(hc).Get(key)
-}
-
-// Set stores the key-value pair.
-func (hc headerCarrier) Set(key string, value string) {
-	http.Header(hc).Set(key, value)
-}
-
-// Keys lists the keys stored in this carrier.
-func (hc headerCarrier) Keys() []string {
-	keys := make([]string, 0, len(hc))
-	for k := range http.Header(hc) {
-		keys = append(keys, k)
-	}
-	return keys
-}
-
-type Message struct {
-	header headerCarrier
-	key    string
-	value  []byte
-}
-
-var (
-	_ Event = (*Message)(nil)
-)
-
-func (m *Message) Key() string {
-	return m.key
-}
-
-func (m *Message) Header() Header {
-	return m.header
-}
-
-func (m *Message) Value() []byte {
-	return m.value
-}
-
-func NewMessage(key string, value []byte) Event {
-	return &Message{
-		key:    key,
-		value:  value,
-		header: headerCarrier{},
-	}
-}
-
 type producer struct {
 }
 
@@ -103,3 +56,201 @@ func TestUow(t *testing.T) {
 	})
 	assert.NoError(t, err)
 }
+
+type recordProducer struct {
+	sent [][]string
+	// fail the first n sends
+	fail int
+	err  error
+}
+
+func (p *recordProducer) Close() error {
+	return nil
+}
+
+func (p *recordProducer) Send(ctx context.Context, msg Event) error {
+	return p.BatchSend(ctx, []Event{msg})
+}
+
+func (p *recordProducer) BatchSend(ctx context.Context, msg []Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if p.fail > 0 {
+		p.fail--
+		return p.err
+	}
+	var keys []string
+	for _, e := range msg {
+		keys = append(keys, e.Key())
+	}
+	p.sent = append(p.sent, keys)
+	return nil
+}
+
+func newRecordManager(p Producer, opts ...TransactionalOption) uow.Manager {
+	return uow.NewManager(func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+		return NewTransactional(ctx, p, opts...), nil
+	})
+}
+
+func TestNestedUow(t *testing.T) {
+	p := &recordProducer{}
+	mgr := newRecordManager(p)
+	transP := NewTransactionalProducer(p, []string{"event"})
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		if err := transP.Send(ctx, NewMessage("1", nil)); err != nil {
+			return err
+		}
+		err := mgr.WithNew(ctx, func(ctx context.Context) error {
+			return transP.Send(ctx, NewMessage("2", nil))
+		})
+		assert.NoError(t, err)
+		err = mgr.WithNew(ctx, func(ctx context.Context) error {
+			if err := transP.Send(ctx, NewMessage("3", nil)); err != nil {
+				return err
+			}
+			return fmt.Errorf("fake error")
+		})
+		assert.Error(t, err)
+		//nothing sent before root commits
+		assert.Empty(t, p.sent)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"1", "2"}}, p.sent)
+}
+
+func TestNestedUowOuterRollback(t *testing.T) {
+	p := &recordProducer{}
+	mgr := newRecordManager(p)
+	transP := NewTransactionalProducer(p, []string{"event"})
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		err := mgr.WithNew(ctx, func(ctx context.Context) error {
+			return transP.Send(ctx, NewMessage("1", nil))
+		})
+		assert.NoError(t, err)
+		return fmt.Errorf("fake error")
+	})
+	assert.Error(t, err)
+	assert.Empty(t, p.sent)
+}
+
+func TestDeliveryRetry(t *testing.T) {
+	p := &recordProducer{fail: 2, err: errors.New("broker down")}
+	mgr := newRecordManager(p, WithRetry(2, ConstantBackoff(time.Millisecond)))
+	transP := NewTransactionalProducer(p, []string{"event"})
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		return transP.Send(ctx, NewMessage("1", nil))
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"1"}}, p.sent)
+}
+
+func TestDeliveryDetachedContext(t *testing.T) {
+	p := &recordProducer{}
+	ctx, cancel := context.WithCancel(context.Background())
+	mgr := newRecordManager(p, WithDetachedContext(time.Second))
+	transP := NewTransactionalProducer(p, []string{"event"})
+	err := mgr.WithNew(ctx, func(ctx context.Context) error {
+		if err := transP.Send(ctx, NewMessage("1", nil)); err != nil {
+			return err
+		}
+		//request is cancelled before commit
+		cancel()
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"1"}}, p.sent)
+}
+
+func TestDeliveryFallback(t *testing.T) {
+	sendErr := errors.New("broker down")
+	p := &recordProducer{fail: 10, err: sendErr}
+	path := filepath.Join(t.TempDir(), "spool.jsonl")
+	var lost []Event
+	mgr := newRecordManager(p, WithRetry(1, nil), WithFallback(NewFileSink(path)), WithLostHandler(func(ctx context.Context, events []Event, err error) {
+		lost = append(lost, events...)
+	}))
+	transP := NewTransactionalProducer(p, []string{"event"})
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		msg := NewMessage("1", []byte("a"))
+		msg.Header().Set("h", "v")
+		return transP.BatchSend(ctx, []Event{msg, NewMessage("2", nil)})
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, lost)
+
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], sendErr.Error())
+
+	//fallback fail
+	storeErr := errors.New("disk full")
+	mgr = newRecordManager(p, WithFallback(SinkFunc(func(ctx context.Context, events []Event, cause error) error {
+		return storeErr
+	})), WithLostHandler(func(ctx context.Context, events []Event, err error) {
+		lost = append(lost, events...)
+	}))
+	err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		return transP.Send(ctx, NewMessage("3", nil))
+	})
+	assert.ErrorIs(t, err, sendErr)
+	assert.ErrorIs(t, err, storeErr)
+	assert.Len(t, lost, 1)
+}
+
+type txProducer struct {
+	recordProducer
+	ops []string
+}
+
+func (p *txProducer) BeginTxn(ctx context.Context) (context.Context, error) {
+	p.ops = append(p.ops, "begin")
+	return ctx, nil
+}
+
+func (p *txProducer) CommitTxn(ctx context.Context) error {
+	p.ops = append(p.ops, "commit")
+	return nil
+}
+
+func (p *txProducer) AbortTxn(ctx context.Context) error {
+	p.ops = append(p.ops, "abort")
+	return nil
+}
+
+func TestTxProducer(t *testing.T) {
+	p := &txProducer{}
+	mgr := newRecordManager(p)
+	transP := NewTransactionalProducer(p, []string{"event"})
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		if err := transP.Send(ctx, NewMessage("1", nil)); err != nil {
+			return err
+		}
+		//sent eagerly
+		assert.Equal(t, [][]string{{"1"}}, p.sent)
+		return mgr.WithNew(ctx, func(ctx context.Context) error {
+			return transP.Send(ctx, NewMessage("2", nil))
+		})
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"1"}, {"2"}}, p.sent)
+	assert.Equal(t, []string{"begin", "commit"}, p.ops)
+
+	err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		if err := transP.Send(ctx, NewMessage("3", nil)); err != nil {
+			return err
+		}
+		return fmt.Errorf("fake error")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, []string{"begin", "commit", "begin", "abort"}, p.ops)
+}
@@ -0,0 +1,279 @@
+package eventstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jace996/uow"
+	"github.com/jace996/uow/event"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnsupportedTxn = errors.New("eventstore: unsupported transaction type")
+	// ErrConcurrency is returned when the stream version is not the expected one
+	ErrConcurrency = errors.New("eventstore: wrong expected version")
+)
+
+const (
+	DefaultTable = "events"
+
+	// AnyVersion skips the optimistic concurrency check
+	AnyVersion int64 = -1
+	// NoStream expects the stream does not exist
+	NoStream int64 = 0
+
+	// HeaderStreamId is the header key of the stream of an event loaded or published by EventStore
+	HeaderStreamId = "Stream-Id"
+	// HeaderStreamVersion is the header key of the version of an event in its stream
+	HeaderStreamVersion = "Stream-Version"
+)
+
+// Record is an event stored in a stream
+type Record struct {
+	// Position is the global position in the store, increasing in append order. Set when loaded
+	Position int64
+	StreamId string
+	// Version is the position in the stream starting from 1
+	Version   int64
+	Key       string
+	Value     []byte
+	Headers   event.MapHeader
+	CreatedAt time.Time
+}
+
+// Event converts Record to event.Event with stream headers
+func (r *Record) Event() event.Event {
+	m := event.NewBuilder(r.Key).Value(r.Value).Headers(r.Headers).Build()
+	m.Header().Set(HeaderStreamId, r.StreamId)
+	m.Header().Set(HeaderStreamVersion, strconv.FormatInt(r.Version, 10))
+	return m
+}
+
+// Snapshot is the state of a stream at Version
+type Snapshot struct {
+	StreamId  string
+	Version   int64
+	Value     []byte
+	CreatedAt time.Time
+}
+
+func newRecords(streamId string, version int64, events []event.Event) []*Record {
+	now := time.Now().UTC()
+	ret := make([]*Record, len(events))
+	for i, e := range events {
+		//id and time are assigned if absent
+		m := event.FromEvent(e)
+		ret[i] = &Record{
+			StreamId:  streamId,
+			Version:   version + int64(i) + 1,
+			Key:       m.Key(),
+			Value:     m.Value(),
+			Headers:   m.Header().(event.MapHeader),
+			CreatedAt: now,
+		}
+	}
+	return ret
+}
+
+func checkVersion(streamId string, expected, current int64) error {
+	if expected != AnyVersion && expected != current {
+		return fmt.Errorf("%w: stream %s is at %d, expected %d", ErrConcurrency, streamId, current, expected)
+	}
+	return nil
+}
+
+// checkInsert maps a unique violation on stream id and version to ErrConcurrency, which means another unit of work appended to the stream concurrently.
+// Drivers are not imported, so it is detected by SQLSTATE or the error message
+func checkInsert(streamId string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var state interface{ SQLState() string }
+	duplicate := errors.As(err, &state) && state.SQLState() == "23505"
+	if msg := strings.ToLower(err.Error()); strings.Contains(msg, "unique constraint") || strings.Contains(msg, "duplicate") {
+		duplicate = true
+	}
+	if duplicate {
+		return fmt.Errorf("%w: stream %s is appended concurrently: %v", ErrConcurrency, streamId, err)
+	}
+	return err
+}
+
+func encodeHeaders(h event.MapHeader) (string, error) {
+	b, err := json.Marshal(h)
+	return string(b), err
+}
+
+func decodeHeaders(s string) (event.MapHeader, error) {
+	h := event.MapHeader{}
+	if len(s) == 0 {
+		return h, nil
+	}
+	err := json.Unmarshal([]byte(s), &h)
+	return h, err
+}
+
+// Store persists streams. tx is resolved from the unit of work, nil when called outside a unit of work
+type Store interface {
+	// Append events after version expected of stream and returns the new version of stream. Returns ErrConcurrency if the stream is at another version or appended concurrently
+	Append(ctx context.Context, tx uow.Txn, streamId string, expected int64, events ...event.Event) ([]*Record, int64, error)
+	// Load events of stream with version greater than after, in order
+	Load(ctx context.Context, tx uow.Txn, streamId string, after int64) ([]*Record, error)
+	// ReadAll loads at most limit events of all streams with position greater than after, in order
+	ReadAll(ctx context.Context, tx uow.Txn, after int64, limit int) ([]*Record, error)
+	// SaveSnapshot replaces the snapshot of stream
+	SaveSnapshot(ctx context.Context, tx uow.Txn, s *Snapshot) error
+	// LoadSnapshot returns nil if stream has no snapshot
+	LoadSnapshot(ctx context.Context, tx uow.Txn, streamId string) (*Snapshot, error)
+}
+
+type options struct {
+	table         string
+	snapshotTable string
+}
+
+type Option func(*options)
+
+// WithTable change the event table name. default is DefaultTable
+func WithTable(table string) Option {
+	return func(o *options) {
+		o.table = table
+	}
+}
+
+// WithSnapshotTable change the snapshot table name. default is the event table name suffixed by "_snapshots"
+func WithSnapshotTable(table string) Option {
+	return func(o *options) {
+		o.snapshotTable = table
+	}
+}
+
+func newOptions(opts ...Option) *options {
+	ret := &options{table: DefaultTable}
+	for _, o := range opts {
+		o(ret)
+	}
+	if len(ret.snapshotTable) == 0 {
+		ret.snapshotTable = ret.table + "_snapshots"
+	}
+	return ret
+}
+
+type eventStoreOptions struct {
+	publisher event.Producer
+}
+
+type EventStoreOption func(*eventStoreOptions)
+
+// WithPublisher send appended events to p in the same unit of work, e.g. a event.TransactionalProducer to publish them after commit
+func WithPublisher(p event.Producer) EventStoreOption {
+	return func(o *eventStoreOptions) {
+		o.publisher = p
+	}
+}
+
+// EventStore appends and loads streams with the transaction resolved by keys from the current unit of work
+type EventStore struct {
+	store Store
+	keys  []string
+	opt   *eventStoreOptions
+}
+
+func New(store Store, keys []string, opts ...EventStoreOption) *EventStore {
+	opt := &eventStoreOptions{}
+	for _, o := range opts {
+		o(opt)
+	}
+	return &EventStore{store: store, keys: keys, opt: opt}
+}
+
+// txn resolves the transaction of current unit of work. nil if not in unit of work
+func (s *EventStore) txn(ctx context.Context) (uow.Txn, error) {
+	u, ok := uow.FromCurrentUow(ctx)
+	if !ok {
+		return nil, nil
+	}
+	return u.GetTxDb(ctx, s.keys...)
+}
+
+// Append events to stream and returns the new version. It must be called inside a unit of work
+func (s *EventStore) Append(ctx context.Context, streamId string, expected int64, events ...event.Event) (int64, error) {
+	if _, ok := uow.FromCurrentUow(ctx); !ok {
+		return 0, uow.ErrUnitOfWorkNotFound
+	}
+	tx, err := s.txn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	records, version, err := s.store.Append(ctx, tx, streamId, expected, events...)
+	if err != nil {
+		return 0, err
+	}
+	if s.opt.publisher != nil && len(records) > 0 {
+		published := make([]event.Event, len(records))
+		for i, r := range records {
+			published[i] = r.Event()
+		}
+		if err := s.opt.publisher.BatchSend(ctx, published); err != nil {
+			return 0, err
+		}
+	}
+	return version, nil
+}
+
+// Load all events of stream
+func (s *EventStore) Load(ctx context.Context, streamId string) ([]*Record, error) {
+	return s.LoadFrom(ctx, streamId, 0)
+}
+
+// LoadFrom loads events of stream with version greater than after
+func (s *EventStore) LoadFrom(ctx context.Context, streamId string, after int64) ([]*Record, error) {
+	tx, err := s.txn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.Load(ctx, tx, streamId, after)
+}
+
+// ReadAll loads at most limit events of all streams with position greater than after
+func (s *EventStore) ReadAll(ctx context.Context, after int64, limit int) ([]*Record, error) {
+	tx, err := s.txn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.ReadAll(ctx, tx, after, limit)
+}
+
+// SaveSnapshot saves state of stream at version
+func (s *EventStore) SaveSnapshot(ctx context.Context, streamId string, version int64, value []byte) error {
+	tx, err := s.txn(ctx)
+	if err != nil {
+		return err
+	}
+	return s.store.SaveSnapshot(ctx, tx, &Snapshot{StreamId: streamId, Version: version, Value: value, CreatedAt: time.Now().UTC()})
+}
+
+// LoadSnapshot loads the latest snapshot of stream and events after it. Snapshot is nil if absent
+func (s *EventStore) LoadSnapshot(ctx context.Context, streamId string) (*Snapshot, []*Record, error) {
+	tx, err := s.txn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	snapshot, err := s.store.LoadSnapshot(ctx, tx, streamId)
+	if err != nil {
+		return nil, nil, err
+	}
+	var after int64
+	if snapshot != nil {
+		after = snapshot.Version
+	}
+	records, err := s.store.Load(ctx, tx, streamId, after)
+	if err != nil {
+		return nil, nil, err
+	}
+	return snapshot, records, nil
+}
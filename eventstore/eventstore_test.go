@@ -0,0 +1,253 @@
+package eventstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jace996/uow"
+	"github.com/jace996/uow/event"
+	"github.com/jace996/uow/event/memory"
+	ugorm "github.com/jace996/uow/gorm"
+	usql "github.com/jace996/uow/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"os"
+	"testing"
+)
+
+const sqlSchema = `CREATE TABLE %[1]s (
+	position INTEGER PRIMARY KEY AUTOINCREMENT,
+	stream_id VARCHAR(255) NOT NULL,
+	version INTEGER NOT NULL,
+	event_key VARCHAR(255) NOT NULL,
+	value BLOB,
+	headers TEXT,
+	created_at TIMESTAMP NOT NULL,
+	UNIQUE (stream_id, version)
+);
+CREATE TABLE %[1]s_snapshots (
+	stream_id VARCHAR(255) PRIMARY KEY,
+	version INTEGER NOT NULL,
+	value BLOB,
+	created_at TIMESTAMP NOT NULL
+)`
+
+var (
+	gormClient *gorm.DB
+	sqlClient  *sql.DB
+)
+
+func TestMain(m *testing.M) {
+	var err error
+	gormClient, err = gorm.Open(sqlite.Open("file:eventstore_gorm.DB?cache=shared&mode=memory"), &gorm.Config{SkipDefaultTransaction: true})
+	if err != nil {
+		panic(err)
+	}
+	db, _ := gormClient.DB()
+	db.SetMaxOpenConns(1)
+
+	sqlClient, err = sql.Open("sqlite3", "file:eventstore_sql.DB?cache=shared&mode=memory")
+	if err != nil {
+		panic(err)
+	}
+	sqlClient.SetMaxOpenConns(1)
+	exitCode := m.Run()
+	os.Exit(exitCode)
+}
+
+type storeCase struct {
+	name    string
+	store   Store
+	factory uow.DbFactory
+}
+
+func storeCases(t *testing.T, table string) []storeCase {
+	gs := NewGormStore(gormClient, WithTable(table))
+	assert.NoError(t, gs.Migrate(context.Background()))
+	_, err := sqlClient.Exec(fmt.Sprintf(sqlSchema, table))
+	assert.NoError(t, err)
+	return []storeCase{
+		{
+			name:  "gorm",
+			store: gs,
+			factory: func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+				return ugorm.NewTransactionDb(gormClient), nil
+			},
+		},
+		{
+			name:  "sql",
+			store: NewSqlStore(sqlClient, usql.Question, WithTable(table)),
+			factory: func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+				return usql.NewTransactionDb(sqlClient), nil
+			},
+		},
+	}
+}
+
+func versions(records []*Record) []string {
+	var ret []string
+	for _, r := range records {
+		ret = append(ret, fmt.Sprintf("%s@%d:%s", r.StreamId, r.Version, r.Value))
+	}
+	return ret
+}
+
+func TestAppendAndLoad(t *testing.T) {
+	for _, c := range storeCases(t, "append_load") {
+		t.Run(c.name, func(t *testing.T) {
+			mgr := uow.NewManager(c.factory)
+			s := New(c.store, nil)
+			err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				v, err := s.Append(ctx, "order-1", NoStream, event.NewMessage("created", []byte("1")), event.NewMessage("paid", []byte("2")))
+				assert.Equal(t, int64(2), v)
+				return err
+			})
+			assert.NoError(t, err)
+
+			//optimistic concurrency
+			err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				_, err := s.Append(ctx, "order-1", 1, event.NewMessage("shipped", []byte("3")))
+				return err
+			})
+			assert.ErrorIs(t, err, ErrConcurrency)
+
+			//rolled back
+			err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				if _, err := s.Append(ctx, "order-1", 2, event.NewMessage("shipped", []byte("3"))); err != nil {
+					return err
+				}
+				return errors.New("fake error")
+			})
+			assert.Error(t, err)
+
+			err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				_, err := s.Append(ctx, "order-2", AnyVersion, event.NewMessage("created", []byte("1")))
+				return err
+			})
+			assert.NoError(t, err)
+
+			records, err := s.Load(context.Background(), "order-1")
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"order-1@1:1", "order-1@2:2"}, versions(records))
+			e := records[1].Event()
+			assert.Equal(t, "paid", e.Key())
+			assert.Equal(t, "order-1", e.Header().Get(HeaderStreamId))
+			assert.Equal(t, "2", e.Header().Get(HeaderStreamVersion))
+			assert.NotEmpty(t, e.Header().Get(event.HeaderId))
+
+			all, err := s.ReadAll(context.Background(), 0, 10)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"order-1@1:1", "order-1@2:2", "order-2@1:1"}, versions(all))
+			all, err = s.ReadAll(context.Background(), all[0].Position, 1)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"order-1@2:2"}, versions(all))
+
+			//no events returns the current version
+			err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				v, err := s.Append(ctx, "order-1", AnyVersion)
+				assert.Equal(t, int64(2), v)
+				return err
+			})
+			assert.NoError(t, err)
+
+			_, err = s.Append(context.Background(), "order-1", AnyVersion)
+			assert.ErrorIs(t, err, uow.ErrUnitOfWorkNotFound)
+		})
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	for _, c := range storeCases(t, "snapshot") {
+		t.Run(c.name, func(t *testing.T) {
+			mgr := uow.NewManager(c.factory)
+			s := New(c.store, nil)
+			snapshot, records, err := s.LoadSnapshot(context.Background(), "order-1")
+			assert.NoError(t, err)
+			assert.Nil(t, snapshot)
+			assert.Empty(t, records)
+
+			err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				for i := int64(0); i < 3; i++ {
+					if _, err := s.Append(ctx, "order-1", i, event.NewMessage("updated", []byte(fmt.Sprint(i+1)))); err != nil {
+						return err
+					}
+					if err := s.SaveSnapshot(ctx, "order-1", i+1, []byte(fmt.Sprintf("state-%d", i+1))); err != nil {
+						return err
+					}
+				}
+				_, err := s.Append(ctx, "order-1", 3, event.NewMessage("updated", []byte("4")))
+				return err
+			})
+			assert.NoError(t, err)
+
+			snapshot, records, err = s.LoadSnapshot(context.Background(), "order-1")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(3), snapshot.Version)
+			assert.Equal(t, []byte("state-3"), snapshot.Value)
+			assert.Equal(t, []string{"order-1@4:4"}, versions(records))
+		})
+	}
+}
+
+func TestPublish(t *testing.T) {
+	for _, c := range storeCases(t, "publish") {
+		t.Run(c.name, func(t *testing.T) {
+			b := memory.NewBroker()
+			defer b.Close()
+			mgr := uow.NewManager(func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+				if len(keys) > 0 && keys[0] == "event" {
+					return event.NewTransactional(ctx, b), nil
+				}
+				return c.factory(ctx, keys...)
+			})
+			s := New(c.store, nil, WithPublisher(event.NewTransactionalProducer(b, []string{"event"})))
+			err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				if _, err := s.Append(ctx, "order-1", NoStream, event.NewMessage("created", nil)); err != nil {
+					return err
+				}
+				//published after commit
+				assert.Empty(t, b.Published())
+				return nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"created"}, b.PublishedKeys())
+			assert.Equal(t, "order-1", b.Published()[0].Header().Get(HeaderStreamId))
+
+			err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				if _, err := s.Append(ctx, "order-1", 1, event.NewMessage("paid", nil)); err != nil {
+					return err
+				}
+				return errors.New("fake error")
+			})
+			assert.Error(t, err)
+			assert.Equal(t, []string{"created"}, b.PublishedKeys())
+		})
+	}
+}
+
+func TestConcurrentAppend(t *testing.T) {
+	storeCases(t, "concurrent")
+	//the row inserted by another unit of work which read the same version
+	insert := "INSERT INTO concurrent (stream_id, version, event_key, created_at) VALUES ('order-1', 1, 'created', CURRENT_TIMESTAMP)"
+	for name, exec := range map[string]func() error{
+		"gorm": func() error {
+			return gormClient.Exec(insert).Error
+		},
+		"sql": func() error {
+			_, err := sqlClient.Exec(insert)
+			return err
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, exec())
+			err := exec()
+			assert.Error(t, err)
+			assert.ErrorIs(t, checkInsert("order-1", err), ErrConcurrency)
+		})
+	}
+	assert.NotErrorIs(t, checkInsert("order-1", errors.New("connection refused")), ErrConcurrency)
+	assert.NoError(t, checkInsert("order-1", nil))
+}
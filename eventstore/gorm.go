@@ -0,0 +1,183 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"github.com/jace996/uow"
+	"github.com/jace996/uow/event"
+	ugorm "github.com/jace996/uow/gorm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type gormEvent struct {
+	Position  int64  `gorm:"primaryKey;autoIncrement"`
+	StreamId  string `gorm:"size:255"`
+	Version   int64
+	EventKey  string `gorm:"size:255"`
+	Value     []byte
+	Headers   string
+	CreatedAt time.Time
+}
+
+func (g *gormEvent) toRecord() (*Record, error) {
+	h, err := decodeHeaders(g.Headers)
+	if err != nil {
+		return nil, err
+	}
+	return &Record{
+		Position:  g.Position,
+		StreamId:  g.StreamId,
+		Version:   g.Version,
+		Key:       g.EventKey,
+		Value:     g.Value,
+		Headers:   h,
+		CreatedAt: g.CreatedAt,
+	}, nil
+}
+
+type gormSnapshot struct {
+	StreamId  string `gorm:"size:255;primaryKey"`
+	Version   int64
+	Value     []byte
+	CreatedAt time.Time
+}
+
+// GormStore stores streams with gorm
+type GormStore struct {
+	db  *gorm.DB
+	opt *options
+}
+
+var _ Store = (*GormStore)(nil)
+
+func NewGormStore(db *gorm.DB, opts ...Option) *GormStore {
+	return &GormStore{db: db, opt: newOptions(opts...)}
+}
+
+// Migrate create or update event and snapshot tables, with a unique index on stream id and version
+func (s *GormStore) Migrate(ctx context.Context) error {
+	db := s.db.WithContext(ctx)
+	if err := db.Table(s.opt.table).AutoMigrate(&gormEvent{}); err != nil {
+		return err
+	}
+	index := "idx_" + s.opt.table + "_stream_version"
+	if !db.Migrator().HasIndex(s.opt.table, index) {
+		if err := db.Exec("CREATE UNIQUE INDEX " + index + " ON " + s.opt.table + " (stream_id, version)").Error; err != nil {
+			return err
+		}
+	}
+	return db.Table(s.opt.snapshotTable).AutoMigrate(&gormSnapshot{})
+}
+
+func (s *GormStore) resolve(tx uow.Txn) (*gorm.DB, error) {
+	if tx == nil {
+		return s.db, nil
+	}
+	t, ok := tx.(*ugorm.TransactionDb)
+	if !ok {
+		return nil, ErrUnsupportedTxn
+	}
+	return t.DB, nil
+}
+
+func (s *GormStore) Append(ctx context.Context, tx uow.Txn, streamId string, expected int64, events ...event.Event) ([]*Record, int64, error) {
+	db, err := s.resolve(tx)
+	if err != nil {
+		return nil, 0, err
+	}
+	db = db.WithContext(ctx)
+	var current int64
+	if err := db.Table(s.opt.table).Where("stream_id = ?", streamId).Select("COALESCE(MAX(version), 0)").Scan(&current).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := checkVersion(streamId, expected, current); err != nil {
+		return nil, 0, err
+	}
+	if len(events) == 0 {
+		return nil, current, nil
+	}
+	records := newRecords(streamId, current, events)
+	rows := make([]*gormEvent, len(records))
+	for i, r := range records {
+		h, err := encodeHeaders(r.Headers)
+		if err != nil {
+			return nil, 0, err
+		}
+		rows[i] = &gormEvent{
+			StreamId:  r.StreamId,
+			Version:   r.Version,
+			EventKey:  r.Key,
+			Value:     r.Value,
+			Headers:   h,
+			CreatedAt: r.CreatedAt,
+		}
+	}
+	if err := db.Table(s.opt.table).Create(rows).Error; err != nil {
+		return nil, 0, checkInsert(streamId, err)
+	}
+	for i, row := range rows {
+		records[i].Position = row.Position
+	}
+	return records, current + int64(len(records)), nil
+}
+
+func (s *GormStore) Load(ctx context.Context, tx uow.Txn, streamId string, after int64) ([]*Record, error) {
+	return s.find(ctx, tx, func(db *gorm.DB) *gorm.DB {
+		return db.Where("stream_id = ? AND version > ?", streamId, after).Order("version")
+	})
+}
+
+func (s *GormStore) ReadAll(ctx context.Context, tx uow.Txn, after int64, limit int) ([]*Record, error) {
+	return s.find(ctx, tx, func(db *gorm.DB) *gorm.DB {
+		return db.Where("position > ?", after).Order("position").Limit(limit)
+	})
+}
+
+func (s *GormStore) find(ctx context.Context, tx uow.Txn, scope func(db *gorm.DB) *gorm.DB) ([]*Record, error) {
+	db, err := s.resolve(tx)
+	if err != nil {
+		return nil, err
+	}
+	var rows []*gormEvent
+	if err := scope(db.WithContext(ctx).Table(s.opt.table)).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	ret := make([]*Record, len(rows))
+	for i, row := range rows {
+		if ret[i], err = row.toRecord(); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func (s *GormStore) SaveSnapshot(ctx context.Context, tx uow.Txn, snapshot *Snapshot) error {
+	db, err := s.resolve(tx)
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Table(s.opt.snapshotTable).Clauses(clause.OnConflict{UpdateAll: true}).Create(&gormSnapshot{
+		StreamId:  snapshot.StreamId,
+		Version:   snapshot.Version,
+		Value:     snapshot.Value,
+		CreatedAt: snapshot.CreatedAt,
+	}).Error
+}
+
+func (s *GormStore) LoadSnapshot(ctx context.Context, tx uow.Txn, streamId string) (*Snapshot, error) {
+	db, err := s.resolve(tx)
+	if err != nil {
+		return nil, err
+	}
+	row := &gormSnapshot{}
+	err = db.WithContext(ctx).Table(s.opt.snapshotTable).Where("stream_id = ?", streamId).Take(row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{StreamId: row.StreamId, Version: row.Version, Value: row.Value, CreatedAt: row.CreatedAt}, nil
+}
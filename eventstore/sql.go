@@ -0,0 +1,147 @@
+package eventstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jace996/uow"
+	"github.com/jace996/uow/event"
+	usql "github.com/jace996/uow/sql"
+)
+
+// SqlStore stores streams with database/sql. Tables should be created in advance, e.g. in sqlite
+//
+//	CREATE TABLE events (
+//		position INTEGER PRIMARY KEY AUTOINCREMENT,
+//		stream_id VARCHAR(255) NOT NULL,
+//		version INTEGER NOT NULL,
+//		event_key VARCHAR(255) NOT NULL,
+//		value BLOB,
+//		headers TEXT,
+//		created_at TIMESTAMP NOT NULL,
+//		UNIQUE (stream_id, version)
+//	);
+//	CREATE TABLE events_snapshots (
+//		stream_id VARCHAR(255) PRIMARY KEY,
+//		version INTEGER NOT NULL,
+//		value BLOB,
+//		created_at TIMESTAMP NOT NULL
+//	);
+type SqlStore struct {
+	db          *sql.DB
+	placeholder usql.Placeholder
+	opt         *options
+}
+
+var _ Store = (*SqlStore)(nil)
+
+func NewSqlStore(db *sql.DB, placeholder usql.Placeholder, opts ...Option) *SqlStore {
+	return &SqlStore{db: db, placeholder: placeholder, opt: newOptions(opts...)}
+}
+
+func (s *SqlStore) resolve(tx uow.Txn) (usql.Executor, error) {
+	if tx == nil {
+		return s.db, nil
+	}
+	t, ok := tx.(*usql.TransactionDb)
+	if !ok {
+		return nil, ErrUnsupportedTxn
+	}
+	return t, nil
+}
+
+func (s *SqlStore) Append(ctx context.Context, tx uow.Txn, streamId string, expected int64, events ...event.Event) ([]*Record, int64, error) {
+	db, err := s.resolve(tx)
+	if err != nil {
+		return nil, 0, err
+	}
+	var current int64
+	err = db.QueryRowContext(ctx, s.placeholder.Rebind("SELECT COALESCE(MAX(version), 0) FROM "+s.opt.table+" WHERE stream_id = ?"), streamId).Scan(&current)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := checkVersion(streamId, expected, current); err != nil {
+		return nil, 0, err
+	}
+	records := newRecords(streamId, current, events)
+	query := s.placeholder.Rebind("INSERT INTO " + s.opt.table + " (stream_id, version, event_key, value, headers, created_at) VALUES (?, ?, ?, ?, ?, ?)")
+	for _, r := range records {
+		h, err := encodeHeaders(r.Headers)
+		if err != nil {
+			return nil, 0, err
+		}
+		res, err := db.ExecContext(ctx, query, r.StreamId, r.Version, r.Key, r.Value, h, r.CreatedAt)
+		if err != nil {
+			return nil, 0, checkInsert(streamId, err)
+		}
+		//not supported by every driver
+		if id, err := res.LastInsertId(); err == nil {
+			r.Position = id
+		}
+	}
+	return records, current + int64(len(records)), nil
+}
+
+func (s *SqlStore) Load(ctx context.Context, tx uow.Txn, streamId string, after int64) ([]*Record, error) {
+	return s.query(ctx, tx, "WHERE stream_id = ? AND version > ? ORDER BY version", streamId, after)
+}
+
+func (s *SqlStore) ReadAll(ctx context.Context, tx uow.Txn, after int64, limit int) ([]*Record, error) {
+	return s.query(ctx, tx, "WHERE position > ? ORDER BY position LIMIT ?", after, limit)
+}
+
+func (s *SqlStore) query(ctx context.Context, tx uow.Txn, where string, args ...interface{}) ([]*Record, error) {
+	db, err := s.resolve(tx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, s.placeholder.Rebind("SELECT position, stream_id, version, event_key, value, headers, created_at FROM "+s.opt.table+" "+where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ret []*Record
+	for rows.Next() {
+		r := &Record{}
+		var h sql.NullString
+		if err := rows.Scan(&r.Position, &r.StreamId, &r.Version, &r.Key, &r.Value, &h, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		if r.Headers, err = decodeHeaders(h.String); err != nil {
+			return nil, err
+		}
+		ret = append(ret, r)
+	}
+	return ret, rows.Err()
+}
+
+// SaveSnapshot deletes and inserts the snapshot, so it should be called inside a unit of work
+func (s *SqlStore) SaveSnapshot(ctx context.Context, tx uow.Txn, snapshot *Snapshot) error {
+	db, err := s.resolve(tx)
+	if err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, s.placeholder.Rebind("DELETE FROM "+s.opt.snapshotTable+" WHERE stream_id = ?"), snapshot.StreamId); err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, s.placeholder.Rebind("INSERT INTO "+s.opt.snapshotTable+" (stream_id, version, value, created_at) VALUES (?, ?, ?, ?)"),
+		snapshot.StreamId, snapshot.Version, snapshot.Value, snapshot.CreatedAt)
+	return err
+}
+
+func (s *SqlStore) LoadSnapshot(ctx context.Context, tx uow.Txn, streamId string) (*Snapshot, error) {
+	db, err := s.resolve(tx)
+	if err != nil {
+		return nil, err
+	}
+	ret := &Snapshot{}
+	err = db.QueryRowContext(ctx, s.placeholder.Rebind("SELECT stream_id, version, value, created_at FROM "+s.opt.snapshotTable+" WHERE stream_id = ?"), streamId).
+		Scan(&ret.StreamId, &ret.Version, &ret.Value, &ret.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,218 @@
+package faulty
+
+import (
+	"context"
+	"database/sql"
+	"github.com/jace996/uow"
+	"sync"
+	"time"
+)
+
+type Op string
+
+const (
+	OpBegin    Op = "begin"
+	OpCommit   Op = "commit"
+	OpRollback Op = "rollback"
+)
+
+// Rule describes a fault injected into an operation of a resource
+type Rule struct {
+	Op Op
+	// Key of the resource, empty matches any key
+	Key string
+	// Nth only applies the rule to the nth (1-based) matched call. 0 applies to every call
+	Nth int
+	// Latency delays the operation
+	Latency time.Duration
+	// Panic panics with this value instead of performing the operation
+	Panic interface{}
+	// Err fails the operation with this error without calling the wrapped resource
+	Err error
+}
+
+// FailBegin fails the nth Begin of key with err
+func FailBegin(key string, nth int, err error) Rule {
+	return Rule{Op: OpBegin, Key: key, Nth: nth, Err: err}
+}
+
+// FailCommit fails the nth Commit of key with err
+func FailCommit(key string, nth int, err error) Rule {
+	return Rule{Op: OpCommit, Key: key, Nth: nth, Err: err}
+}
+
+// FailRollback fails every Rollback of key with err
+func FailRollback(key string, err error) Rule {
+	return Rule{Op: OpRollback, Key: key, Err: err}
+}
+
+// PanicOnBegin panics with v inside every Begin of key
+func PanicOnBegin(key string, v interface{}) Rule {
+	return Rule{Op: OpBegin, Key: key, Panic: v}
+}
+
+// Latency delays every op of key by d
+func Latency(op Op, key string, d time.Duration) Rule {
+	return Rule{Op: op, Key: key, Latency: d}
+}
+
+// Injector wraps uow.TransactionalDb and injects faults by rules. It is safe for concurrent use
+type Injector struct {
+	mtx    sync.Mutex
+	rules  []Rule
+	counts map[Op]map[string]int
+}
+
+func New(rules ...Rule) *Injector {
+	return &Injector{
+		rules:  rules,
+		counts: map[Op]map[string]int{},
+	}
+}
+
+// Add appends rules
+func (i *Injector) Add(rules ...Rule) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	i.rules = append(i.rules, rules...)
+}
+
+// Reset drops all rules and counters
+func (i *Injector) Reset() {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	i.rules = nil
+	i.counts = map[Op]map[string]int{}
+}
+
+// Count returns how many times op of key has been called
+func (i *Injector) Count(op Op, key string) int {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	return i.counts[op][key]
+}
+
+// Factory wraps every resource resolved by f. Keys are formatted by uow.DefaultKeyFormatter
+func (i *Injector) Factory(f uow.DbFactory) uow.DbFactory {
+	return func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+		db, err := f(ctx, keys...)
+		if err != nil {
+			return nil, err
+		}
+		return i.Wrap(uow.DefaultKeyFormatter(keys...), db), nil
+	}
+}
+
+// Wrap db of key
+func (i *Injector) Wrap(key string, db uow.TransactionalDb) uow.TransactionalDb {
+	return &transactionalDb{key: key, inj: i, db: db}
+}
+
+func (i *Injector) match(op Op, key string) []Rule {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	if i.counts[op] == nil {
+		i.counts[op] = map[string]int{}
+	}
+	i.counts[op][key]++
+	n := i.counts[op][key]
+	var ret []Rule
+	for _, r := range i.rules {
+		if r.Op != op || (len(r.Key) > 0 && r.Key != key) {
+			continue
+		}
+		if r.Nth > 0 && r.Nth != n {
+			continue
+		}
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+// inject applies matched rules. Returns non nil error if the operation should fail
+func (i *Injector) inject(op Op, key string) error {
+	rules := i.match(op, key)
+	for _, r := range rules {
+		if r.Latency > 0 {
+			time.Sleep(r.Latency)
+		}
+	}
+	for _, r := range rules {
+		if r.Panic != nil {
+			panic(r.Panic)
+		}
+		if r.Err != nil {
+			return r.Err
+		}
+	}
+	return nil
+}
+
+type transactionalDb struct {
+	key string
+	inj *Injector
+	db  uow.TransactionalDb
+}
+
+func (t *transactionalDb) Begin(opt ...*sql.TxOptions) (uow.Txn, error) {
+	if err := t.inj.inject(OpBegin, t.key); err != nil {
+		return nil, err
+	}
+	tx, err := t.db.Begin(opt...)
+	if err != nil {
+		return nil, err
+	}
+	ret := &txn{key: t.key, inj: t.inj, tx: tx}
+	if db, ok := tx.(uow.TransactionalDb); ok {
+		//keep nested transaction working
+		return &nestedTxn{txn: ret, db: &transactionalDb{key: t.key, inj: t.inj, db: db}}, nil
+	}
+	return ret, nil
+}
+
+type txn struct {
+	key string
+	inj *Injector
+	tx  uow.Txn
+}
+
+func (t *txn) Commit() error {
+	if err := t.inj.inject(OpCommit, t.key); err != nil {
+		return err
+	}
+	return t.tx.Commit()
+}
+
+func (t *txn) Rollback() error {
+	if err := t.inj.inject(OpRollback, t.key); err != nil {
+		return err
+	}
+	return t.tx.Rollback()
+}
+
+type nestedTxn struct {
+	*txn
+	db *transactionalDb
+}
+
+func (n *nestedTxn) Begin(opt ...*sql.TxOptions) (uow.Txn, error) {
+	return n.db.Begin(opt...)
+}
+
+var (
+	_ uow.TransactionalDb = (*transactionalDb)(nil)
+	_ uow.Txn             = (*txn)(nil)
+	_ uow.TransactionalDb = (*nestedTxn)(nil)
+	_ uow.Txn             = (*nestedTxn)(nil)
+)
+
+// Unwrap returns the transaction wrapped by Injector. Other transactions are returned as is
+func Unwrap(tx uow.Txn) uow.Txn {
+	switch t := tx.(type) {
+	case *txn:
+		return t.tx
+	case *nestedTxn:
+		return t.tx
+	}
+	return tx
+}
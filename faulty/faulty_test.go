@@ -0,0 +1,151 @@
+package faulty
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/jace996/uow"
+	uhttp "github.com/jace996/uow/http"
+	"github.com/jace996/uow/kratos"
+	"github.com/jace996/uow/mock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newManager(inj *Injector, opts ...uow.Option) (uow.Manager, *mock.Recorder) {
+	r := mock.NewRecorder()
+	return uow.NewManager(inj.Factory(func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+		return mock.NewFakeDb(uow.DefaultKeyFormatter(keys...), r), nil
+	}), opts...), r
+}
+
+func useKeys(keys ...string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		u, _ := uow.FromCurrentUow(ctx)
+		for _, k := range keys {
+			tx, err := u.GetTxDb(ctx, k)
+			if err != nil {
+				return err
+			}
+			if _, ok := Unwrap(tx).(*mock.FakeDb); !ok {
+				return errors.New("unexpected transaction type")
+			}
+		}
+		return nil
+	}
+}
+
+func TestFailCommit(t *testing.T) {
+	commitErr := errors.New("commit error")
+	inj := New(FailCommit("b", 2, commitErr))
+	mgr, r := newManager(inj)
+
+	err := mgr.WithNew(context.Background(), useKeys("a", "b"))
+	assert.NoError(t, err)
+	r.AssertCommitted(t, "b")
+
+	r.Reset()
+	err = mgr.WithNew(context.Background(), useKeys("a", "b"))
+	assert.ErrorIs(t, err, commitErr)
+	//commit b fail, rollback all
+	r.AssertRolledBack(t, "a")
+	assert.False(t, r.Committed("b"))
+	assert.Equal(t, 2, inj.Count(OpCommit, "b"))
+}
+
+func TestFailRollback(t *testing.T) {
+	rollbackErr := errors.New("rollback error")
+	fnErr := errors.New("fn error")
+	mgr, r := newManager(New(FailRollback("a", rollbackErr)))
+
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		if err := useKeys("a", "b")(ctx); err != nil {
+			return err
+		}
+		return fnErr
+	})
+	assert.ErrorIs(t, err, fnErr)
+	assert.Contains(t, err.Error(), rollbackErr.Error())
+	//other resources still rollback
+	r.AssertRolledBack(t, "b")
+	assert.False(t, r.RolledBack("a"))
+}
+
+func TestPanicOnBegin(t *testing.T) {
+	mgr, r := newManager(New(PanicOnBegin("b", "boom")))
+	assert.PanicsWithValue(t, "boom", func() {
+		_ = mgr.WithNew(context.Background(), useKeys("a", "b"))
+	})
+	r.AssertRolledBack(t, "a")
+	r.AssertNotBegan(t, "b")
+}
+
+func TestLatency(t *testing.T) {
+	mgr, r := newManager(New(Latency(OpCommit, "", 20*time.Millisecond)))
+	start := time.Now()
+	err := mgr.WithNew(context.Background(), useKeys("a"))
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	r.AssertCommitted(t, "a")
+}
+
+func TestNested(t *testing.T) {
+	beginErr := errors.New("begin error")
+	inj := New(FailBegin("a", 2, beginErr))
+	mgr, r := newManager(inj)
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		if err := useKeys("a")(ctx); err != nil {
+			return err
+		}
+		err := mgr.WithNew(ctx, useKeys("a"))
+		assert.ErrorIs(t, err, beginErr)
+		return nil
+	})
+	assert.NoError(t, err)
+	r.AssertCommitted(t, "a")
+	assert.Len(t, r.CallsOf("a"), 2)
+}
+
+func TestHttpMiddleware(t *testing.T) {
+	commitErr := errors.New("commit error")
+	mgr, r := newManager(New(FailCommit("a", 0, commitErr)))
+	var encoded error
+	h := uhttp.Uow(mgr, func(w http.ResponseWriter, r *http.Request) error {
+		return useKeys("a")(r.Context())
+	}, uhttp.WithErrorEncoder(func(w http.ResponseWriter, r *http.Request, err error) {
+		encoded = err
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.ErrorIs(t, encoded, commitErr)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	r.AssertRolledBack(t, "a")
+}
+
+type fakeTransport struct {
+	operation string
+}
+
+func (f *fakeTransport) Kind() transport.Kind            { return transport.KindGRPC }
+func (f *fakeTransport) Endpoint() string                { return "" }
+func (f *fakeTransport) Operation() string               { return f.operation }
+func (f *fakeTransport) RequestHeader() transport.Header { return nil }
+func (f *fakeTransport) ReplyHeader() transport.Header   { return nil }
+
+func TestKratosMiddleware(t *testing.T) {
+	commitErr := errors.New("commit error")
+	mgr, r := newManager(New(FailCommit("a", 0, commitErr)))
+	h := kratos.Uow(mgr)(func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", useKeys("a")(ctx)
+	})
+	ctx := transport.NewServerContext(context.Background(), &fakeTransport{operation: "/test.Service/CreatePost"})
+	_, err := h(ctx, nil)
+	assert.ErrorIs(t, err, commitErr)
+	r.AssertRolledBack(t, "a")
+}
@@ -0,0 +1,59 @@
+package inbox
+
+import (
+	"context"
+	"github.com/jace996/uow"
+	ugorm "github.com/jace996/uow/gorm"
+	"gorm.io/gorm"
+	"time"
+)
+
+type gormRecord struct {
+	Consumer  string    `gorm:"size:128;primaryKey"`
+	Id        string    `gorm:"size:64;primaryKey"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// GormStore records handled event ids with gorm
+type GormStore struct {
+	db  *gorm.DB
+	opt *storeOptions
+}
+
+var _ Store = (*GormStore)(nil)
+
+func NewGormStore(db *gorm.DB, opts ...StoreOption) *GormStore {
+	return &GormStore{db: db, opt: newStoreOptions(opts...)}
+}
+
+// Migrate create or update inbox table
+func (s *GormStore) Migrate(ctx context.Context) error {
+	return s.db.WithContext(ctx).Table(s.opt.table).AutoMigrate(&gormRecord{})
+}
+
+func (s *GormStore) Insert(ctx context.Context, tx uow.Txn, consumer, id string) (bool, error) {
+	db := s.db
+	if tx != nil {
+		t, ok := tx.(*ugorm.TransactionDb)
+		if !ok {
+			return false, ErrUnsupportedTxn
+		}
+		db = t.DB
+	}
+	var n int64
+	err := db.WithContext(ctx).Table(s.opt.table).Where("consumer = ? AND id = ?", consumer, id).Count(&n).Error
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return false, nil
+	}
+	//concurrent duplicate fails with primary key violation and rolls back the unit of work
+	err = db.WithContext(ctx).Table(s.opt.table).Create(&gormRecord{Consumer: consumer, Id: id, CreatedAt: time.Now().UTC()}).Error
+	return err == nil, err
+}
+
+func (s *GormStore) Purge(ctx context.Context, consumer string, before time.Time) (int64, error) {
+	res := s.db.WithContext(ctx).Table(s.opt.table).Where("consumer = ? AND created_at < ?", consumer, before.UTC()).Delete(&gormRecord{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,157 @@
+package inbox
+
+import (
+	"context"
+	"errors"
+	"github.com/jace996/uow"
+	"github.com/jace996/uow/event"
+	"time"
+)
+
+var (
+	ErrMissingId      = errors.New("inbox: event id header is missing")
+	ErrUnsupportedTxn = errors.New("inbox: unsupported transaction type")
+)
+
+const (
+	DefaultTable    = "inbox"
+	DefaultConsumer = "default"
+)
+
+// Store records handled event ids
+type Store interface {
+	// Insert records id handled by consumer with tx resolved from the unit of work. Returns false if id has already been recorded
+	Insert(ctx context.Context, tx uow.Txn, consumer, id string) (bool, error)
+	// Purge deletes ids handled by consumer and recorded before t
+	Purge(ctx context.Context, consumer string, before time.Time) (int64, error)
+}
+
+type storeOptions struct {
+	table string
+}
+
+type StoreOption func(*storeOptions)
+
+// WithTable change the inbox table name. default is DefaultTable
+func WithTable(table string) StoreOption {
+	return func(o *storeOptions) {
+		o.table = table
+	}
+}
+
+func newStoreOptions(opts ...StoreOption) *storeOptions {
+	ret := &storeOptions{table: DefaultTable}
+	for _, o := range opts {
+		o(ret)
+	}
+	return ret
+}
+
+type options struct {
+	consumer  string
+	idHeader  string
+	retention time.Duration
+}
+
+type Option func(*options)
+
+// WithConsumer change the consumer name. Different consumers handle the same event independently. default is DefaultConsumer
+func WithConsumer(consumer string) Option {
+	return func(o *options) {
+		o.consumer = consumer
+	}
+}
+
+// WithIdHeader change the header key of event id. default is event.HeaderId
+func WithIdHeader(key string) Option {
+	return func(o *options) {
+		o.idHeader = key
+	}
+}
+
+// WithRetention change how long handled ids are kept. default 7 days
+func WithRetention(d time.Duration) Option {
+	return func(o *options) {
+		o.retention = d
+	}
+}
+
+// Inbox makes event handlers idempotent. Handled event ids are recorded with the transaction resolved by keys,
+// so keys should be the same as the database written by handlers
+type Inbox struct {
+	store Store
+	keys  []string
+	opt   *options
+}
+
+func New(store Store, keys []string, opts ...Option) *Inbox {
+	opt := &options{
+		consumer:  DefaultConsumer,
+		idHeader:  event.HeaderId,
+		retention: 7 * 24 * time.Hour,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	return &Inbox{store: store, keys: keys, opt: opt}
+}
+
+// Handle run fn for e only if e has not been handled. It must be called inside a unit of work
+func (i *Inbox) Handle(ctx context.Context, e event.Event, fn func(ctx context.Context) error) error {
+	var id string
+	if h := e.Header(); h != nil {
+		id = h.Get(i.opt.idHeader)
+	}
+	if len(id) == 0 {
+		return ErrMissingId
+	}
+	u, ok := uow.FromCurrentUow(ctx)
+	if !ok {
+		return uow.ErrUnitOfWorkNotFound
+	}
+	tx, err := u.GetTxDb(ctx, i.keys...)
+	if err != nil {
+		return err
+	}
+	inserted, err := i.store.Insert(ctx, tx, i.opt.consumer, id)
+	if err != nil {
+		return err
+	}
+	if !inserted {
+		//duplicate
+		return nil
+	}
+	return fn(ctx)
+}
+
+// Cleanup deletes ids of the consumer older than retention. Ids of other consumers are kept by their own retention
+func (i *Inbox) Cleanup(ctx context.Context) (int64, error) {
+	return i.store.Purge(ctx, i.opt.consumer, time.Now().Add(-i.opt.retention))
+}
+
+// RunCleanup calls Cleanup every interval until ctx is done
+func (i *Inbox) RunCleanup(ctx context.Context, interval time.Duration, errHandler func(err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := i.Cleanup(ctx); err != nil && errHandler != nil {
+				errHandler(err)
+			}
+		}
+	}
+}
+
+// Middleware skips events handled before. It must be used inside event.Uow
+func Middleware(i *Inbox) event.Middleware {
+	return func(next event.Handler) event.Handler {
+		return func(ctx context.Context, e event.Event) error {
+			return i.Handle(ctx, e, func(ctx context.Context) error {
+				return next(ctx, e)
+			})
+		}
+	}
+}
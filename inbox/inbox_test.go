@@ -0,0 +1,175 @@
+package inbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jace996/uow"
+	"github.com/jace996/uow/event"
+	ugorm "github.com/jace996/uow/gorm"
+	usql "github.com/jace996/uow/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"os"
+	"testing"
+	"time"
+)
+
+const sqlSchema = `CREATE TABLE inbox (
+	consumer VARCHAR(128) NOT NULL,
+	id VARCHAR(64) NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (consumer, id)
+)`
+
+var (
+	gormClient *gorm.DB
+	sqlClient  *sql.DB
+)
+
+func TestMain(m *testing.M) {
+	var err error
+	gormClient, err = gorm.Open(sqlite.Open("file:inbox_gorm.DB?cache=shared&mode=memory"), &gorm.Config{SkipDefaultTransaction: true})
+	if err != nil {
+		panic(err)
+	}
+	db, _ := gormClient.DB()
+	db.SetMaxOpenConns(1)
+	if err = NewGormStore(gormClient).Migrate(context.Background()); err != nil {
+		panic(err)
+	}
+
+	sqlClient, err = sql.Open("sqlite3", "file:inbox_sql.DB?cache=shared&mode=memory")
+	if err != nil {
+		panic(err)
+	}
+	sqlClient.SetMaxOpenConns(1)
+	if _, err = sqlClient.Exec(sqlSchema); err != nil {
+		panic(err)
+	}
+	exitCode := m.Run()
+	os.Exit(exitCode)
+}
+
+func newMessage(id string) event.Event {
+	return event.NewBuilder("test").Id(id).Build()
+}
+
+type storeCase struct {
+	name    string
+	store   Store
+	factory uow.DbFactory
+}
+
+func storeCases() []storeCase {
+	return []storeCase{
+		{
+			name:  "gorm",
+			store: NewGormStore(gormClient),
+			factory: func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+				return ugorm.NewTransactionDb(gormClient), nil
+			},
+		},
+		{
+			name:  "sql",
+			store: NewSqlStore(sqlClient, usql.Question),
+			factory: func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+				return usql.NewTransactionDb(sqlClient), nil
+			},
+		},
+	}
+}
+
+func TestHandle(t *testing.T) {
+	for _, c := range storeCases() {
+		t.Run(c.name, func(t *testing.T) {
+			mgr := uow.NewManager(c.factory)
+			ib := New(c.store, nil)
+			handled := 0
+			handle := func(e event.Event, err error) error {
+				return mgr.WithNew(context.Background(), func(ctx context.Context) error {
+					return ib.Handle(ctx, e, func(ctx context.Context) error {
+						handled++
+						return err
+					})
+				})
+			}
+
+			//failed handler rolls back the id
+			fakeErr := errors.New("fake error")
+			assert.ErrorIs(t, handle(newMessage("1"), fakeErr), fakeErr)
+			assert.Equal(t, 1, handled)
+
+			assert.NoError(t, handle(newMessage("1"), nil))
+			assert.Equal(t, 2, handled)
+			//duplicate is skipped
+			assert.NoError(t, handle(newMessage("1"), nil))
+			assert.Equal(t, 2, handled)
+
+			//other consumer handle independently
+			other := New(c.store, nil, WithConsumer("other"))
+			err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				return other.Handle(ctx, newMessage("1"), func(ctx context.Context) error {
+					handled++
+					return nil
+				})
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, 3, handled)
+
+			noId := event.NewMessage("test", nil)
+			noId.Header().(event.MapHeader).Del(event.HeaderId)
+			assert.ErrorIs(t, handle(noId, nil), ErrMissingId)
+			assert.ErrorIs(t, ib.Handle(context.Background(), newMessage("2"), nil), uow.ErrUnitOfWorkNotFound)
+		})
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	for _, c := range storeCases() {
+		t.Run(c.name, func(t *testing.T) {
+			mgr := uow.NewManager(c.factory)
+			ib := New(c.store, nil, WithConsumer("cleanup"), WithRetention(-time.Minute))
+			other := New(c.store, nil, WithConsumer("cleanup-other"))
+			err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				noop := func(ctx context.Context) error {
+					return nil
+				}
+				if err := ib.Handle(ctx, newMessage("1"), noop); err != nil {
+					return err
+				}
+				return other.Handle(ctx, newMessage("1"), noop)
+			})
+			assert.NoError(t, err)
+			n, err := ib.Cleanup(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, int64(1), n)
+
+			//ids of other consumers are kept
+			handled := false
+			err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				return other.Handle(ctx, newMessage("1"), func(ctx context.Context) error {
+					handled = true
+					return nil
+				})
+			})
+			assert.NoError(t, err)
+			assert.False(t, handled)
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	c := storeCases()[0]
+	ib := New(c.store, nil, WithConsumer("middleware"))
+	handled := 0
+	h := event.ChainHandler(func(ctx context.Context, e event.Event) error {
+		handled++
+		return nil
+	}, event.Uow(uow.NewManager(c.factory)), Middleware(ib))
+	assert.NoError(t, h(context.Background(), newMessage("1")))
+	assert.NoError(t, h(context.Background(), newMessage("1")))
+	assert.Equal(t, 1, handled)
+}
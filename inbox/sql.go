@@ -0,0 +1,60 @@
+package inbox
+
+import (
+	"context"
+	"database/sql"
+	"github.com/jace996/uow"
+	usql "github.com/jace996/uow/sql"
+	"time"
+)
+
+// SqlStore records handled event ids with database/sql. The table should be created in advance, e.g.
+//
+//	CREATE TABLE inbox (
+//		consumer VARCHAR(128) NOT NULL,
+//		id VARCHAR(64) NOT NULL,
+//		created_at TIMESTAMP NOT NULL,
+//		PRIMARY KEY (consumer, id)
+//	);
+//	CREATE INDEX idx_inbox_created_at ON inbox (created_at);
+type SqlStore struct {
+	db          *sql.DB
+	placeholder usql.Placeholder
+	opt         *storeOptions
+}
+
+var _ Store = (*SqlStore)(nil)
+
+func NewSqlStore(db *sql.DB, placeholder usql.Placeholder, opts ...StoreOption) *SqlStore {
+	return &SqlStore{db: db, placeholder: placeholder, opt: newStoreOptions(opts...)}
+}
+
+func (s *SqlStore) Insert(ctx context.Context, tx uow.Txn, consumer, id string) (bool, error) {
+	var db usql.Executor = s.db
+	if tx != nil {
+		t, ok := tx.(*usql.TransactionDb)
+		if !ok {
+			return false, ErrUnsupportedTxn
+		}
+		db = t
+	}
+	var n int
+	err := db.QueryRowContext(ctx, s.placeholder.Rebind("SELECT COUNT(*) FROM "+s.opt.table+" WHERE consumer = ? AND id = ?"), consumer, id).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return false, nil
+	}
+	//concurrent duplicate fails with primary key violation and rolls back the unit of work
+	_, err = db.ExecContext(ctx, s.placeholder.Rebind("INSERT INTO "+s.opt.table+" (consumer, id, created_at) VALUES (?, ?, ?)"), consumer, id, time.Now().UTC())
+	return err == nil, err
+}
+
+func (s *SqlStore) Purge(ctx context.Context, consumer string, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, s.placeholder.Rebind("DELETE FROM "+s.opt.table+" WHERE consumer = ? AND created_at < ?"), consumer, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
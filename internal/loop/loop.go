@@ -0,0 +1,111 @@
+package loop
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Config of a Loop
+type Config struct {
+	// Run processes one batch and returns the number of processed items. It is called again immediately if the batch is full
+	Run       func(ctx context.Context) (int, error)
+	BatchSize int
+	Interval  time.Duration
+	// Notify wakes up the loop before Interval passes. Optional
+	Notify <-chan struct{}
+	// ErrHandler handles errors returned by Run
+	ErrHandler func(err error)
+	// ErrStarted is returned by Start if the loop is running
+	ErrStarted error
+}
+
+// Loop calls Run until stopped. Start and Stop match kratos transport.Server, so background workers can be registered into a kratos app
+type Loop struct {
+	c       Config
+	mtx     sync.Mutex
+	stop    chan struct{}
+	done    chan struct{}
+	stopped bool
+}
+
+func New(c Config) *Loop {
+	return &Loop{c: c}
+}
+
+// Start runs the loop and blocks until Stop is called or ctx is done. The in-flight batch is not interrupted by cancellation.
+// Returns immediately if Stop has been called before
+func (l *Loop) Start(ctx context.Context) error {
+	l.mtx.Lock()
+	if l.stopped {
+		l.mtx.Unlock()
+		return nil
+	}
+	if l.stop != nil {
+		l.mtx.Unlock()
+		return l.c.ErrStarted
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	l.stop, l.done = stop, done
+	l.mtx.Unlock()
+
+	defer func() {
+		l.mtx.Lock()
+		l.stop, l.done = nil, nil
+		l.mtx.Unlock()
+		close(done)
+	}()
+
+	runCtx := context.WithoutCancel(ctx)
+	ticker := time.NewTicker(l.c.Interval)
+	defer ticker.Stop()
+	for {
+		n, err := l.c.Run(runCtx)
+		if err != nil {
+			l.c.ErrHandler(err)
+		}
+		if err == nil && n >= l.c.BatchSize {
+			//more items may be pending
+			select {
+			case <-stop:
+				return nil
+			case <-ctx.Done():
+				return nil
+			default:
+				continue
+			}
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-ctx.Done():
+			return nil
+		case <-l.c.Notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop the loop gracefully, a later Start returns immediately. The in-flight batch is finished unless ctx is done first
+func (l *Loop) Stop(ctx context.Context) error {
+	l.mtx.Lock()
+	l.stopped = true
+	stop, done := l.stop, l.done
+	if stop != nil {
+		select {
+		case <-stop:
+		default:
+			close(stop)
+		}
+	}
+	l.mtx.Unlock()
+	if done == nil {
+		return nil
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,64 @@
+package loop
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLoop(t *testing.T) {
+	errStarted := errors.New("started")
+	batches := make(chan int, 10)
+	pending := 5
+	l := New(Config{
+		Run: func(ctx context.Context) (int, error) {
+			n := pending
+			if n > 2 {
+				n = 2
+			}
+			pending -= n
+			batches <- n
+			return n, nil
+		},
+		BatchSize:  2,
+		Interval:   time.Hour,
+		ErrHandler: func(err error) {},
+		ErrStarted: errStarted,
+	})
+	go l.Start(context.Background())
+	//full batches run again without waiting for the interval
+	for _, n := range []int{2, 2, 1} {
+		assert.Equal(t, n, <-batches)
+	}
+	assert.Eventually(t, func() bool {
+		return errors.Is(l.Start(context.Background()), errStarted)
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, l.Stop(ctx))
+	assert.NoError(t, l.Start(context.Background()))
+}
+
+func TestStopBeforeStart(t *testing.T) {
+	l := New(Config{
+		Run: func(ctx context.Context) (int, error) {
+			return 0, nil
+		},
+		Interval:   time.Millisecond,
+		ErrHandler: func(err error) {},
+	})
+	assert.NoError(t, l.Stop(context.Background()))
+	done := make(chan error)
+	go func() {
+		done <- l.Start(context.Background())
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("started after stop")
+	}
+}
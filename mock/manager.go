@@ -0,0 +1,28 @@
+package mock
+
+import (
+	"context"
+	"github.com/jace996/uow"
+)
+
+// FakeManager is a uow.Manager backed by FakeDb. Every key resolves to a FakeDb recording into the embedded Recorder.
+// Keys are recorded as formatted by uow.DefaultKeyFormatter
+type FakeManager struct {
+	uow.Manager
+	*Recorder
+}
+
+var _ uow.Manager = (*FakeManager)(nil)
+
+func NewFakeManager(opts ...uow.Option) *FakeManager {
+	m := &FakeManager{
+		Recorder: NewRecorder(),
+	}
+	m.Manager = uow.NewManager(m.Factory, opts...)
+	return m
+}
+
+// Factory is the uow.DbFactory used by this manager
+func (m *FakeManager) Factory(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+	return NewFakeDb(uow.DefaultKeyFormatter(keys...), m.Recorder), nil
+}
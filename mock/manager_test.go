@@ -1,3 +1,63 @@
 package mock
 
-// TODO
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jace996/uow"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type fakeT struct {
+	failed bool
+}
+
+func (f *fakeT) Errorf(format string, args ...interface{}) {
+	f.failed = true
+}
+
+func TestFakeManagerCommit(t *testing.T) {
+	mgr := NewFakeManager()
+	opt := &sql.TxOptions{ReadOnly: true}
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		u, _ := uow.FromCurrentUow(ctx)
+		_, err := u.GetTxDb(ctx, "a")
+		return err
+	}, opt)
+	assert.NoError(t, err)
+
+	mgr.AssertBegan(t, "a")
+	mgr.AssertCommitted(t, "a")
+	mgr.AssertNotBegan(t, "b")
+	calls := mgr.CallsOf("a")
+	assert.Equal(t, []Call{
+		{Op: OpBegin, Key: "a", Depth: 0, Opt: []*sql.TxOptions{opt}},
+		{Op: OpCommit, Key: "a", Depth: 0},
+	}, calls)
+
+	ft := &fakeT{}
+	assert.False(t, mgr.AssertRolledBack(ft, "a"))
+	assert.True(t, ft.failed)
+
+	mgr.Reset()
+	assert.Empty(t, mgr.Calls())
+}
+
+func TestFakeManagerRollback(t *testing.T) {
+	mgr := NewFakeManager()
+	fakeErr := errors.New("fake error")
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		u, _ := uow.FromCurrentUow(ctx)
+		if _, err := u.GetTxDb(ctx, "a", "b"); err != nil {
+			return err
+		}
+		return fakeErr
+	})
+	assert.ErrorIs(t, err, fakeErr)
+	mgr.AssertRolledBack(t, "a/b")
+
+	ft := &fakeT{}
+	assert.False(t, mgr.AssertCommitted(ft, "a/b"))
+	assert.True(t, ft.failed)
+}
@@ -1,24 +1,179 @@
 package mock
 
 import (
-	"context"
 	"database/sql"
-	"log"
+	"fmt"
+	"github.com/jace996/uow"
+	"github.com/stretchr/testify/assert"
+	"sync"
 )
 
-type nonTransactionDb struct {
+type Op string
+
+const (
+	OpBegin    Op = "begin"
+	OpCommit   Op = "commit"
+	OpRollback Op = "rollback"
+)
+
+// Call is a single operation recorded by FakeDb
+type Call struct {
+	Op  Op
+	Key string
+	// Depth is the nesting level of the transaction. 0 is the outermost transaction of a key
+	Depth int
+	Opt   []*sql.TxOptions
+}
+
+func (c Call) String() string {
+	return fmt.Sprintf("%s %s@%d", c.Op, c.Key, c.Depth)
+}
+
+// Recorder collects calls made to FakeDb instances. It is safe for concurrent use
+type Recorder struct {
+	mtx   sync.Mutex
+	calls []Call
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) record(c Call) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.calls = append(r.calls, c)
+}
+
+// Calls returns a copy of all recorded calls in order
+func (r *Recorder) Calls() []Call {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	ret := make([]Call, len(r.calls))
+	copy(ret, r.calls)
+	return ret
+}
+
+// CallsOf returns recorded calls of key in order
+func (r *Recorder) CallsOf(key string) []Call {
+	var ret []Call
+	for _, c := range r.Calls() {
+		if c.Key == key {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// Reset drops all recorded calls
+func (r *Recorder) Reset() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.calls = nil
+}
+
+// Began reports whether a transaction of key has been started
+func (r *Recorder) Began(key string) bool {
+	return r.has(key, OpBegin)
+}
+
+// Committed reports whether the outermost transaction of key has been committed
+func (r *Recorder) Committed(key string) bool {
+	return r.has(key, OpCommit)
+}
+
+// RolledBack reports whether the outermost transaction of key has been rolled back
+func (r *Recorder) RolledBack(key string) bool {
+	return r.has(key, OpRollback)
+}
+
+func (r *Recorder) has(key string, op Op) bool {
+	for _, c := range r.CallsOf(key) {
+		if c.Op == op && c.Depth == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// AssertBegan asserts that a transaction of key has been started
+func (r *Recorder) AssertBegan(t assert.TestingT, key string, msgAndArgs ...interface{}) bool {
+	if r.Began(key) {
+		return true
+	}
+	return assert.Fail(t, fmt.Sprintf("transaction %q not began. calls: %v", key, r.Calls()), msgAndArgs...)
+}
+
+// AssertNotBegan asserts that no transaction of key has been started
+func (r *Recorder) AssertNotBegan(t assert.TestingT, key string, msgAndArgs ...interface{}) bool {
+	if !r.Began(key) {
+		return true
+	}
+	return assert.Fail(t, fmt.Sprintf("transaction %q should not begin. calls: %v", key, r.Calls()), msgAndArgs...)
+}
+
+// AssertCommitted asserts that the outermost transaction of key has been committed and not rolled back
+func (r *Recorder) AssertCommitted(t assert.TestingT, key string, msgAndArgs ...interface{}) bool {
+	if r.Committed(key) && !r.RolledBack(key) {
+		return true
+	}
+	return assert.Fail(t, fmt.Sprintf("transaction %q not committed. calls: %v", key, r.Calls()), msgAndArgs...)
+}
+
+// AssertRolledBack asserts that the outermost transaction of key has been rolled back and not committed
+func (r *Recorder) AssertRolledBack(t assert.TestingT, key string, msgAndArgs ...interface{}) bool {
+	if r.RolledBack(key) && !r.Committed(key) {
+		return true
+	}
+	return assert.Fail(t, fmt.Sprintf("transaction %q not rolled back. calls: %v", key, r.Calls()), msgAndArgs...)
+}
+
+// FakeDb is an in memory uow.TransactionalDb which records every Begin, Commit and Rollback into a Recorder
+type FakeDb struct {
+	key      string
+	depth    int
+	recorder *Recorder
+}
+
+var (
+	_ uow.TransactionalDb = (*FakeDb)(nil)
+	_ uow.Txn             = (*FakeDb)(nil)
+)
+
+// NewFakeDb create a non-transactional FakeDb of key. Transactions begun from it are recorded into r
+func NewFakeDb(key string, r *Recorder) *FakeDb {
+	return &FakeDb{
+		key:      key,
+		depth:    -1,
+		recorder: r,
+	}
+}
+
+func (f *FakeDb) Key() string {
+	return f.key
+}
+
+// Depth returns the nesting level of this transaction. -1 means not in transaction
+func (f *FakeDb) Depth() int {
+	return f.depth
 }
 
-func (n *nonTransactionDb) Begin(ctx context.Context, opt ...*sql.TxOptions) (db interface{}, err error) {
-	return n, nil
+func (f *FakeDb) Begin(opt ...*sql.TxOptions) (uow.Txn, error) {
+	tx := &FakeDb{
+		key:      f.key,
+		depth:    f.depth + 1,
+		recorder: f.recorder,
+	}
+	f.recorder.record(Call{Op: OpBegin, Key: f.key, Depth: tx.depth, Opt: opt})
+	return tx, nil
 }
 
-func (n *nonTransactionDb) Commit() error {
-	log.Println("Commit")
+func (f *FakeDb) Commit() error {
+	f.recorder.record(Call{Op: OpCommit, Key: f.key, Depth: f.depth})
 	return nil
 }
 
-func (n *nonTransactionDb) Rollback() error {
-	log.Println("Rollback")
+func (f *FakeDb) Rollback() error {
+	f.recorder.record(Call{Op: OpRollback, Key: f.key, Depth: f.depth})
 	return nil
 }
@@ -1,3 +1,172 @@
 package mock
 
-// TODO
+import (
+	"context"
+	"errors"
+	"github.com/jace996/uow"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func getTxDb(ctx context.Context, keys ...string) error {
+	u, ok := uow.FromCurrentUow(ctx)
+	if !ok {
+		return uow.ErrUnitOfWorkNotFound
+	}
+	_, err := u.GetTxDb(ctx, keys...)
+	return err
+}
+
+func TestCommitReverseOrder(t *testing.T) {
+	mgr := NewFakeManager()
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		if err := getTxDb(ctx, "a"); err != nil {
+			return err
+		}
+		if err := getTxDb(ctx, "b"); err != nil {
+			return err
+		}
+		//resolve again will not begin new transaction
+		return getTxDb(ctx, "a")
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Call{
+		{Op: OpBegin, Key: "a"},
+		{Op: OpBegin, Key: "b"},
+		{Op: OpCommit, Key: "b"},
+		{Op: OpCommit, Key: "a"},
+	}, mgr.Calls())
+}
+
+func TestPanicRollback(t *testing.T) {
+	mgr := NewFakeManager()
+	assert.Panics(t, func() {
+		_ = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+			if err := getTxDb(ctx, "a"); err != nil {
+				return err
+			}
+			panic("fake")
+		})
+	})
+	mgr.AssertRolledBack(t, "a")
+}
+
+func TestNested(t *testing.T) {
+	mgr := NewFakeManager()
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		if err := getTxDb(ctx, "a"); err != nil {
+			return err
+		}
+		err := mgr.WithNew(ctx, func(ctx context.Context) error {
+			if err := getTxDb(ctx, "a"); err != nil {
+				return err
+			}
+			return errors.New("fake error")
+		})
+		assert.Error(t, err)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Call{
+		{Op: OpBegin, Key: "a", Depth: 0},
+		{Op: OpBegin, Key: "a", Depth: 1},
+		{Op: OpRollback, Key: "a", Depth: 1},
+		{Op: OpCommit, Key: "a", Depth: 0},
+	}, mgr.Calls())
+	mgr.AssertCommitted(t, "a")
+}
+
+func TestNestedDisable(t *testing.T) {
+	mgr := NewFakeManager(uow.WithDisableNestedNestedTransaction())
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		if err := getTxDb(ctx, "a"); err != nil {
+			return err
+		}
+		return mgr.WithNew(ctx, func(ctx context.Context) error {
+			return getTxDb(ctx, "a")
+		})
+	})
+	assert.NoError(t, err)
+	assert.Len(t, mgr.CallsOf("a"), 2)
+	mgr.AssertCommitted(t, "a")
+}
+
+func TestWithoutUow(t *testing.T) {
+	err := getTxDb(context.Background(), "a")
+	assert.ErrorIs(t, err, uow.ErrUnitOfWorkNotFound)
+	err = uow.WithCurrentUnitOfWork(context.Background(), func(ctx context.Context) error {
+		return nil
+	})
+	assert.ErrorIs(t, err, uow.ErrUnitOfWorkNotFound)
+}
+
+func TestOnCommitted(t *testing.T) {
+	mgr := NewFakeManager()
+	var called []string
+	hook := func(name string) func(ctx context.Context) {
+		return func(ctx context.Context) {
+			u, _ := uow.FromCurrentUow(ctx)
+			u.OnCommitted(func() {
+				called = append(called, name)
+			})
+		}
+	}
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		hook("root")(ctx)
+		_ = mgr.WithNew(ctx, func(ctx context.Context) error {
+			hook("committed child")(ctx)
+			return nil
+		})
+		//not called before root commits
+		assert.Empty(t, called)
+		_ = mgr.WithNew(ctx, func(ctx context.Context) error {
+			hook("rolled back child")(ctx)
+			return errors.New("fake error")
+		})
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"root", "committed child"}, called)
+
+	called = nil
+	_ = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		hook("root")(ctx)
+		return errors.New("fake error")
+	})
+	assert.Empty(t, called)
+}
+
+func TestOnBeforeCommit(t *testing.T) {
+	mgr := NewFakeManager()
+	var called []string
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		u, _ := uow.FromCurrentUow(ctx)
+		u.OnBeforeCommit(func() error {
+			called = append(called, "first")
+			//hooks can enlist resources and register more hooks
+			u.OnBeforeCommit(func() error {
+				called = append(called, "second")
+				return nil
+			})
+			return getTxDb(ctx, "a")
+		})
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, called)
+	mgr.AssertCommitted(t, "a")
+
+	mgr.Reset()
+	err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		if err := getTxDb(ctx, "a"); err != nil {
+			return err
+		}
+		u, _ := uow.FromCurrentUow(ctx)
+		u.OnBeforeCommit(func() error {
+			return errors.New("fake error")
+		})
+		return nil
+	})
+	assert.Error(t, err)
+	mgr.AssertRolledBack(t, "a")
+}
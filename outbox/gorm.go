@@ -0,0 +1,211 @@
+package outbox
+
+import (
+	"context"
+	"github.com/jace996/uow"
+	ugorm "github.com/jace996/uow/gorm"
+	"gorm.io/gorm"
+	"time"
+)
+
+type gormRecord struct {
+	Seq         uint64 `gorm:"primaryKey;autoIncrement"`
+	Id          string `gorm:"size:64;uniqueIndex"`
+	EventKey    string `gorm:"size:255"`
+	Value       []byte
+	Headers     string
+	CreatedAt   time.Time
+	AvailableAt time.Time `gorm:"index"`
+	Attempts    int
+	LastError   string
+	LeaseOwner  *string `gorm:"size:64"`
+	LeaseUntil  *time.Time
+	DeliveredAt *time.Time `gorm:"index"`
+}
+
+func (g *gormRecord) toRecord() (*Record, error) {
+	h, err := decodeHeaders(g.Headers)
+	if err != nil {
+		return nil, err
+	}
+	return &Record{
+		Id:          g.Id,
+		Key:         g.EventKey,
+		Value:       g.Value,
+		Headers:     h,
+		CreatedAt:   g.CreatedAt,
+		AvailableAt: g.AvailableAt,
+		Attempts:    g.Attempts,
+		LastError:   g.LastError,
+	}, nil
+}
+
+type gormDeadLetter struct {
+	Id        string `gorm:"size:64;primaryKey"`
+	EventKey  string `gorm:"size:255"`
+	Value     []byte
+	Headers   string
+	CreatedAt time.Time
+	Attempts  int
+	LastError string
+	DeadAt    time.Time
+}
+
+// GormStore stores outbox records with gorm
+type GormStore struct {
+	db  *gorm.DB
+	opt *options
+}
+
+var _ RelayStore = (*GormStore)(nil)
+
+func NewGormStore(db *gorm.DB, opts ...Option) *GormStore {
+	return &GormStore{db: db, opt: newOptions(opts...)}
+}
+
+// Migrate create or update outbox and dead letter tables
+func (s *GormStore) Migrate(ctx context.Context) error {
+	if err := s.db.WithContext(ctx).Table(s.opt.table).AutoMigrate(&gormRecord{}); err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Table(s.opt.deadLetterTable).AutoMigrate(&gormDeadLetter{})
+}
+
+func (s *GormStore) resolve(tx uow.Txn) (*gorm.DB, error) {
+	if tx == nil {
+		return s.db, nil
+	}
+	t, ok := tx.(*ugorm.TransactionDb)
+	if !ok {
+		return nil, ErrUnsupportedTxn
+	}
+	return t.DB, nil
+}
+
+func (s *GormStore) Save(ctx context.Context, tx uow.Txn, records ...*Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	db, err := s.resolve(tx)
+	if err != nil {
+		return err
+	}
+	rows := make([]*gormRecord, len(records))
+	for i, r := range records {
+		h, err := encodeHeaders(r.Headers)
+		if err != nil {
+			return err
+		}
+		rows[i] = &gormRecord{
+			Id:          r.Id,
+			EventKey:    r.Key,
+			Value:       r.Value,
+			Headers:     h,
+			CreatedAt:   r.CreatedAt,
+			AvailableAt: r.AvailableAt,
+			Attempts:    r.Attempts,
+		}
+	}
+	return db.WithContext(ctx).Table(s.opt.table).Create(rows).Error
+}
+
+func (s *GormStore) Cancel(ctx context.Context, tx uow.Txn, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	db, err := s.resolve(tx)
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Table(s.opt.table).Where("id IN ? AND delivered_at IS NULL", ids).Delete(&gormRecord{}).Error
+}
+
+func (s *GormStore) Lease(ctx context.Context, owner string, limit int, ttl time.Duration) ([]*Record, error) {
+	now := time.Now().UTC()
+	var rows []*gormRecord
+	err := s.db.WithContext(ctx).Table(s.opt.table).
+		Where("delivered_at IS NULL AND available_at <= ? AND (lease_until IS NULL OR lease_until < ?)", now, now).
+		Order("seq").Limit(limit).Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	until := now.Add(ttl)
+	var ret []*Record
+	for _, row := range rows {
+		//claim the record if no other owner claimed it
+		res := s.db.WithContext(ctx).Table(s.opt.table).
+			Where("id = ? AND delivered_at IS NULL AND (lease_until IS NULL OR lease_until < ?)", row.Id, now).
+			Updates(map[string]interface{}{"lease_owner": owner, "lease_until": until})
+		if res.Error != nil {
+			return ret, res.Error
+		}
+		if res.RowsAffected != 1 {
+			continue
+		}
+		r, err := row.toRecord()
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+func (s *GormStore) MarkDelivered(ctx context.Context, owner string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	res := s.db.WithContext(ctx).Table(s.opt.table).Where("id IN ? AND lease_owner = ? AND delivered_at IS NULL", ids, owner).
+		Updates(map[string]interface{}{"delivered_at": time.Now().UTC(), "lease_owner": nil, "lease_until": nil})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected < int64(len(ids)) {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (s *GormStore) MarkFailed(ctx context.Context, owner string, r *Record, next time.Time) error {
+	res := s.db.WithContext(ctx).Table(s.opt.table).Where("id = ? AND lease_owner = ? AND delivered_at IS NULL", r.Id, owner).
+		Updates(map[string]interface{}{
+			"attempts":     r.Attempts,
+			"last_error":   r.LastError,
+			"available_at": next.UTC(),
+			"lease_owner":  nil,
+			"lease_until":  nil,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (s *GormStore) DeadLetter(ctx context.Context, owner string, r *Record) error {
+	h, err := encodeHeaders(r.Headers)
+	if err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Table(s.opt.table).Where("id = ? AND lease_owner = ? AND delivered_at IS NULL", r.Id, owner).Delete(&gormRecord{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrLeaseLost
+		}
+		return tx.Table(s.opt.deadLetterTable).Create(&gormDeadLetter{
+			Id:        r.Id,
+			EventKey:  r.Key,
+			Value:     r.Value,
+			Headers:   h,
+			CreatedAt: r.CreatedAt,
+			Attempts:  r.Attempts,
+			LastError: r.LastError,
+			DeadAt:    time.Now().UTC(),
+		}).Error
+	})
+}
@@ -0,0 +1,229 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jace996/uow"
+	"github.com/jace996/uow/event"
+	"time"
+)
+
+var (
+	ErrUnsupportedTxn = errors.New("outbox: unsupported transaction type")
+	ErrLeaseLost      = errors.New("outbox: lease of record is lost")
+)
+
+const (
+	DefaultTable = "outbox"
+)
+
+// Record is an event stored in outbox table
+type Record struct {
+	Id        string
+	Key       string
+	Value     []byte
+	Headers   event.MapHeader
+	CreatedAt time.Time
+	// AvailableAt is the time when the record can be relayed
+	AvailableAt time.Time
+	// Attempts is the number of failed deliveries
+	Attempts  int
+	LastError string
+}
+
+// NewRecord create a Record from e. Id is taken from event.HeaderId or generated.
+// The record is available at event.HeaderDeliverAt if present
+func NewRecord(e event.Event) *Record {
+	now := time.Now().UTC()
+	r := &Record{
+		Key:         e.Key(),
+		Value:       e.Value(),
+		Headers:     event.CloneHeader(e.Header()),
+		CreatedAt:   now,
+		AvailableAt: now,
+	}
+	r.Id = r.Headers.Get(event.HeaderId)
+	if len(r.Id) == 0 {
+		r.Id = uuid.New().String()
+		r.Headers.Set(event.HeaderId, r.Id)
+	}
+	if at, ok := event.DeliverAt(e); ok && at.After(now) {
+		r.AvailableAt = at.UTC()
+	}
+	return r
+}
+
+// Event converts Record back to event.Event
+func (r *Record) Event() event.Event {
+	return event.NewBuilder(r.Key).Value(r.Value).Headers(r.Headers).Build()
+}
+
+func encodeHeaders(h event.MapHeader) (string, error) {
+	b, err := json.Marshal(h)
+	return string(b), err
+}
+
+func decodeHeaders(s string) (event.MapHeader, error) {
+	h := event.MapHeader{}
+	if len(s) == 0 {
+		return h, nil
+	}
+	err := json.Unmarshal([]byte(s), &h)
+	return h, err
+}
+
+// Store persists outbox records
+type Store interface {
+	// Save records with tx resolved from the unit of work. tx is nil when called outside a unit of work
+	Save(ctx context.Context, tx uow.Txn, records ...*Record) error
+	// Cancel deletes records which are not delivered yet. tx is nil when called outside a unit of work
+	Cancel(ctx context.Context, tx uow.Txn, ids ...string) error
+}
+
+// RelayStore is the Store used by Relay
+type RelayStore interface {
+	Store
+	// Lease claims at most limit available records for owner until ttl expires. Leased records are invisible to other owners
+	Lease(ctx context.Context, owner string, limit int, ttl time.Duration) ([]*Record, error)
+	// MarkDelivered marks records leased by owner as delivered. Returns ErrLeaseLost if any record is no longer leased by owner
+	MarkDelivered(ctx context.Context, owner string, ids ...string) error
+	// MarkFailed releases the lease of a failed record and makes it available again at next. Returns ErrLeaseLost if the record is no longer leased by owner
+	MarkFailed(ctx context.Context, owner string, r *Record, next time.Time) error
+	// DeadLetter moves a record leased by owner into dead letter table. Returns ErrLeaseLost if the record is no longer leased by owner
+	DeadLetter(ctx context.Context, owner string, r *Record) error
+}
+
+type options struct {
+	table           string
+	deadLetterTable string
+}
+
+type Option func(*options)
+
+// WithTable change the outbox table name. default is DefaultTable
+func WithTable(table string) Option {
+	return func(o *options) {
+		o.table = table
+	}
+}
+
+// WithDeadLetterTable change the dead letter table name. default is the outbox table name suffixed by "_dead_letter"
+func WithDeadLetterTable(table string) Option {
+	return func(o *options) {
+		o.deadLetterTable = table
+	}
+}
+
+func newOptions(opts ...Option) *options {
+	ret := &options{table: DefaultTable}
+	for _, o := range opts {
+		o(ret)
+	}
+	if len(ret.deadLetterTable) == 0 {
+		ret.deadLetterTable = ret.table + "_dead_letter"
+	}
+	return ret
+}
+
+// Notifier is notified after records are saved and committed
+type Notifier interface {
+	Notify()
+}
+
+type producerOptions struct {
+	notifier Notifier
+}
+
+type ProducerOption func(*producerOptions)
+
+// WithNotifier notify n after the unit of work which saves records commits, e.g. a Relay to deliver immediately
+func WithNotifier(n Notifier) ProducerOption {
+	return func(o *producerOptions) {
+		o.notifier = n
+	}
+}
+
+// Producer is an event.Producer which writes events into outbox table.
+// Inside a unit of work, events are written with the transaction resolved by keys,
+// so keys should be the same as the database of business data to make them atomic.
+// It is also an event.Scheduler: events with deliver-at time are relayed when due
+type Producer struct {
+	store Store
+	keys  []string
+	opt   *producerOptions
+}
+
+var (
+	_ event.Producer  = (*Producer)(nil)
+	_ event.Scheduler = (*Producer)(nil)
+)
+
+func NewProducer(store Store, keys []string, opts ...ProducerOption) *Producer {
+	opt := &producerOptions{}
+	for _, o := range opts {
+		o(opt)
+	}
+	return &Producer{store: store, keys: keys, opt: opt}
+}
+
+func (p *Producer) Close() error {
+	return nil
+}
+
+func (p *Producer) Send(ctx context.Context, msg event.Event) error {
+	return p.BatchSend(ctx, []event.Event{msg})
+}
+
+func (p *Producer) BatchSend(ctx context.Context, msg []event.Event) error {
+	if len(msg) == 0 {
+		return nil
+	}
+	records := make([]*Record, len(msg))
+	for i, e := range msg {
+		records[i] = NewRecord(e)
+	}
+	if u, ok := uow.FromCurrentUow(ctx); ok {
+		//resolve transaction from unit of work
+		tx, err := u.GetTxDb(ctx, p.keys...)
+		if err != nil {
+			return err
+		}
+		if err := p.store.Save(ctx, tx, records...); err != nil {
+			return err
+		}
+		if p.opt.notifier != nil {
+			u.OnCommitted(p.opt.notifier.Notify)
+		}
+		return nil
+	}
+	if err := p.store.Save(ctx, nil, records...); err != nil {
+		return err
+	}
+	if p.opt.notifier != nil {
+		p.opt.notifier.Notify()
+	}
+	return nil
+}
+
+// Schedule saves events like BatchSend. Relay delivers them not before their deliver-at time
+func (p *Producer) Schedule(ctx context.Context, events []event.Event) error {
+	return p.BatchSend(ctx, events)
+}
+
+// Cancel deletes undelivered events. Events already leased by a relay may still be delivered
+func (p *Producer) Cancel(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if u, ok := uow.FromCurrentUow(ctx); ok {
+		//resolve transaction from unit of work
+		tx, err := u.GetTxDb(ctx, p.keys...)
+		if err != nil {
+			return err
+		}
+		return p.store.Cancel(ctx, tx, ids...)
+	}
+	return p.store.Cancel(ctx, nil, ids...)
+}
@@ -0,0 +1,189 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jace996/uow"
+	"github.com/jace996/uow/event"
+	ugorm "github.com/jace996/uow/gorm"
+	usql "github.com/jace996/uow/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"os"
+	"testing"
+)
+
+const sqlSchema = `CREATE TABLE %[1]s (
+	seq INTEGER PRIMARY KEY AUTOINCREMENT,
+	id VARCHAR(64) NOT NULL UNIQUE,
+	event_key VARCHAR(255) NOT NULL,
+	value BLOB,
+	headers TEXT,
+	created_at TIMESTAMP NOT NULL,
+	available_at TIMESTAMP NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	lease_owner VARCHAR(64),
+	lease_until TIMESTAMP,
+	delivered_at TIMESTAMP
+);
+CREATE TABLE %[1]s_dead_letter (
+	id VARCHAR(64) PRIMARY KEY,
+	event_key VARCHAR(255) NOT NULL,
+	value BLOB,
+	headers TEXT,
+	created_at TIMESTAMP NOT NULL,
+	attempts INTEGER NOT NULL,
+	last_error TEXT,
+	dead_at TIMESTAMP NOT NULL
+)`
+
+type post struct {
+	Id uint
+}
+
+var (
+	gormClient *gorm.DB
+	sqlClient  *sql.DB
+)
+
+func TestMain(m *testing.M) {
+	var err error
+	gormClient, err = gorm.Open(sqlite.Open("file:outbox_gorm.DB?cache=shared&mode=memory"), &gorm.Config{SkipDefaultTransaction: true})
+	if err != nil {
+		panic(err)
+	}
+	db, _ := gormClient.DB()
+	db.SetMaxOpenConns(1)
+	if err = gormClient.AutoMigrate(&post{}); err != nil {
+		panic(err)
+	}
+	if err = NewGormStore(gormClient).Migrate(context.Background()); err != nil {
+		panic(err)
+	}
+
+	sqlClient, err = sql.Open("sqlite3", "file:outbox_sql.DB?cache=shared&mode=memory")
+	if err != nil {
+		panic(err)
+	}
+	sqlClient.SetMaxOpenConns(1)
+	if _, err = sqlClient.Exec(fmt.Sprintf(sqlSchema, DefaultTable)); err != nil {
+		panic(err)
+	}
+	if _, err = sqlClient.Exec("CREATE TABLE posts (id INTEGER PRIMARY KEY)"); err != nil {
+		panic(err)
+	}
+	exitCode := m.Run()
+	os.Exit(exitCode)
+}
+
+func newMessage(key string, value string) event.Event {
+	return event.NewMessage(key, []byte(value))
+}
+
+func gormCount(t *testing.T, table string, where string, args ...interface{}) int64 {
+	var n int64
+	assert.NoError(t, gormClient.Table(table).Where(where, args...).Count(&n).Error)
+	return n
+}
+
+func TestGormProducer(t *testing.T) {
+	mgr := uow.NewManager(func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+		return ugorm.NewTransactionDb(gormClient), nil
+	})
+	p := NewProducer(NewGormStore(gormClient), nil)
+
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		u, _ := uow.FromCurrentUow(ctx)
+		tx, err := u.GetTxDb(ctx)
+		if err != nil {
+			return err
+		}
+		if err := tx.(*ugorm.TransactionDb).Create(&post{Id: 1}).Error; err != nil {
+			return err
+		}
+		e := newMessage("post.created", "1")
+		e.Header().Set(event.HeaderId, "gorm-1")
+		return p.Send(ctx, e)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), gormCount(t, DefaultTable, "id = ? AND event_key = ?", "gorm-1", "post.created"))
+
+	err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		e := newMessage("post.created", "2")
+		e.Header().Set(event.HeaderId, "gorm-2")
+		if err := p.BatchSend(ctx, []event.Event{e}); err != nil {
+			return err
+		}
+		return errors.New("fake error")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, int64(0), gormCount(t, DefaultTable, "id = ?", "gorm-2"))
+
+	//outside unit of work
+	e := newMessage("post.created", "3")
+	e.Header().Set(event.HeaderId, "gorm-3")
+	assert.NoError(t, p.Send(context.Background(), e))
+	assert.Equal(t, int64(1), gormCount(t, DefaultTable, "id = ?", "gorm-3"))
+}
+
+func sqlCount(t *testing.T, query string, args ...interface{}) int {
+	var n int
+	assert.NoError(t, sqlClient.QueryRow(query, args...).Scan(&n))
+	return n
+}
+
+func TestSqlProducer(t *testing.T) {
+	mgr := uow.NewManager(func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+		return usql.NewTransactionDb(sqlClient), nil
+	})
+	p := NewProducer(NewSqlStore(sqlClient, usql.Question), nil)
+
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		u, _ := uow.FromCurrentUow(ctx)
+		tx, err := u.GetTxDb(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.(*usql.TransactionDb).ExecContext(ctx, "INSERT INTO posts (id) VALUES (1)"); err != nil {
+			return err
+		}
+		return p.Send(ctx, newMessage("post.created", "1"))
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sqlCount(t, "SELECT COUNT(*) FROM outbox WHERE event_key = ?", "post.created"))
+
+	err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		if err := p.Send(ctx, newMessage("post.deleted", "1")); err != nil {
+			return err
+		}
+		return errors.New("fake error")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 0, sqlCount(t, "SELECT COUNT(*) FROM outbox WHERE event_key = ?", "post.deleted"))
+}
+
+type failProducer struct {
+	err error
+}
+
+func (f *failProducer) Close() error                                         { return nil }
+func (f *failProducer) Send(ctx context.Context, msg event.Event) error      { return f.err }
+func (f *failProducer) BatchSend(ctx context.Context, m []event.Event) error { return f.err }
+
+func TestSink(t *testing.T) {
+	store := NewGormStore(gormClient)
+	mgr := uow.NewManager(func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+		return event.NewTransactional(ctx, &failProducer{err: errors.New("broker down")}, event.WithFallback(NewSink(store))), nil
+	})
+	p := event.NewTransactionalProducer(&failProducer{}, []string{"event"})
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		return p.Send(ctx, newMessage("post.sink", "1"))
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), gormCount(t, DefaultTable, "event_key = ?", "post.sink"))
+}
@@ -0,0 +1,186 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jace996/uow/event"
+	"github.com/jace996/uow/internal/loop"
+	"time"
+)
+
+var (
+	ErrRelayStarted = errors.New("outbox: relay already started")
+)
+
+type relayOptions struct {
+	owner       string
+	batchSize   int
+	interval    time.Duration
+	leaseTTL    time.Duration
+	maxAttempts int
+	backoff     event.Backoff
+	errHandler  func(err error)
+}
+
+type RelayOption func(*relayOptions)
+
+// WithOwner change the lease owner of this relay instance. default is a random uuid
+func WithOwner(owner string) RelayOption {
+	return func(o *relayOptions) {
+		o.owner = owner
+	}
+}
+
+// WithBatchSize change the max number of records sent in one BatchSend. default 100
+func WithBatchSize(n int) RelayOption {
+	return func(o *relayOptions) {
+		o.batchSize = n
+	}
+}
+
+// WithInterval change the polling interval. default 1s
+func WithInterval(d time.Duration) RelayOption {
+	return func(o *relayOptions) {
+		o.interval = d
+	}
+}
+
+// WithLeaseTTL change how long leased records are invisible to other relay instances. default 30s
+func WithLeaseTTL(d time.Duration) RelayOption {
+	return func(o *relayOptions) {
+		o.leaseTTL = d
+	}
+}
+
+// WithMaxAttempts move records into dead letter table after n failed deliveries. default 10
+func WithMaxAttempts(n int) RelayOption {
+	return func(o *relayOptions) {
+		o.maxAttempts = n
+	}
+}
+
+// WithBackoff change the retry backoff of failed records. default exponential from 1s to 5m
+func WithBackoff(b event.Backoff) RelayOption {
+	return func(o *relayOptions) {
+		o.backoff = b
+	}
+}
+
+// WithErrorHandler handle errors of the relay loop. default ignore
+func WithErrorHandler(f func(err error)) RelayOption {
+	return func(o *relayOptions) {
+		o.errHandler = f
+	}
+}
+
+// Relay ships outbox records to a event.Producer with at-least-once delivery.
+// Multiple relay instances can share one store, records are leased to avoid concurrent delivery
+type Relay struct {
+	store    RelayStore
+	producer event.Producer
+	opt      *relayOptions
+
+	notify chan struct{}
+	loop   *loop.Loop
+}
+
+var _ Notifier = (*Relay)(nil)
+
+func NewRelay(store RelayStore, producer event.Producer, opts ...RelayOption) *Relay {
+	opt := &relayOptions{
+		owner:       uuid.New().String(),
+		batchSize:   100,
+		interval:    time.Second,
+		leaseTTL:    30 * time.Second,
+		maxAttempts: 10,
+		backoff:     event.ExponentialBackoff(time.Second, 5*time.Minute),
+		errHandler:  func(err error) {},
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	r := &Relay{
+		store:    store,
+		producer: producer,
+		opt:      opt,
+		notify:   make(chan struct{}, 1),
+	}
+	r.loop = loop.New(loop.Config{
+		Run:        r.RelayOnce,
+		BatchSize:  opt.batchSize,
+		Interval:   opt.interval,
+		Notify:     r.notify,
+		ErrHandler: opt.errHandler,
+		ErrStarted: ErrRelayStarted,
+	})
+	return r
+}
+
+// Notify wakes up the relay loop immediately
+func (r *Relay) Notify() {
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the relay loop and blocks until Stop is called or ctx is done, so Relay can be used as a kratos transport.Server
+func (r *Relay) Start(ctx context.Context) error {
+	return r.loop.Start(ctx)
+}
+
+// Stop the relay loop gracefully. The in-flight batch is finished unless ctx is done first
+func (r *Relay) Stop(ctx context.Context) error {
+	return r.loop.Stop(ctx)
+}
+
+// RelayOnce leases and sends one batch of records. Returns the number of leased records
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	records, err := r.store.Lease(ctx, r.opt.owner, r.opt.batchSize, r.opt.leaseTTL)
+	if err != nil {
+		return 0, err
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+	events := make([]event.Event, len(records))
+	for i, rec := range records {
+		events[i] = rec.Event()
+	}
+	sendErr := r.producer.BatchSend(ctx, events)
+	errs := make([]error, len(records))
+	var be *event.BatchError
+	if errors.As(sendErr, &be) && len(be.Errs) == len(records) {
+		//partial failure
+		copy(errs, be.Errs)
+	} else if sendErr != nil {
+		for i := range errs {
+			errs[i] = sendErr
+		}
+	}
+	ret := []error{sendErr}
+	var delivered []string
+	for i, rec := range records {
+		if errs[i] == nil {
+			delivered = append(delivered, rec.Id)
+			continue
+		}
+		if err := r.fail(ctx, rec, errs[i]); err != nil {
+			ret = append(ret, err)
+		}
+	}
+	if err := r.store.MarkDelivered(ctx, r.opt.owner, delivered...); err != nil {
+		ret = append(ret, err)
+	}
+	return len(records), errors.Join(ret...)
+}
+
+func (r *Relay) fail(ctx context.Context, rec *Record, sendErr error) error {
+	rec.Attempts++
+	rec.LastError = sendErr.Error()
+	if rec.Attempts >= r.opt.maxAttempts {
+		return r.store.DeadLetter(ctx, r.opt.owner, rec)
+	}
+	return r.store.MarkFailed(ctx, r.opt.owner, rec, time.Now().Add(r.opt.backoff(rec.Attempts)))
+}
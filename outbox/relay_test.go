@@ -0,0 +1,251 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jace996/uow"
+	"github.com/jace996/uow/event"
+	ugorm "github.com/jace996/uow/gorm"
+	usql "github.com/jace996/uow/sql"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordProducer struct {
+	mtx  sync.Mutex
+	sent []event.Event
+	err  error
+}
+
+func (p *recordProducer) Close() error {
+	return nil
+}
+
+func (p *recordProducer) Send(ctx context.Context, msg event.Event) error {
+	return p.BatchSend(ctx, []event.Event{msg})
+}
+
+func (p *recordProducer) BatchSend(ctx context.Context, msg []event.Event) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.sent = append(p.sent, msg...)
+	return nil
+}
+
+func (p *recordProducer) keys() []string {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	var ret []string
+	for _, e := range p.sent {
+		ret = append(ret, e.Key())
+	}
+	return ret
+}
+
+type storeCase struct {
+	name    string
+	store   RelayStore
+	factory uow.DbFactory
+	count   func(t *testing.T, table string, where string, args ...interface{}) int64
+}
+
+func storeCases(t *testing.T, table string) []storeCase {
+	gs := NewGormStore(gormClient, WithTable(table))
+	assert.NoError(t, gs.Migrate(context.Background()))
+	_, err := sqlClient.Exec(fmt.Sprintf(sqlSchema, table))
+	assert.NoError(t, err)
+	return []storeCase{
+		{
+			name:  "gorm",
+			store: gs,
+			factory: func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+				return ugorm.NewTransactionDb(gormClient), nil
+			},
+			count: gormCount,
+		},
+		{
+			name:  "sql",
+			store: NewSqlStore(sqlClient, usql.Question, WithTable(table)),
+			factory: func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+				return usql.NewTransactionDb(sqlClient), nil
+			},
+			count: func(t *testing.T, table string, where string, args ...interface{}) int64 {
+				return int64(sqlCount(t, "SELECT COUNT(*) FROM "+table+" WHERE "+where, args...))
+			},
+		},
+	}
+}
+
+func TestRelayOnce(t *testing.T) {
+	for _, c := range storeCases(t, "relay_once") {
+		t.Run(c.name, func(t *testing.T) {
+			mgr := uow.NewManager(c.factory)
+			p := NewProducer(c.store, nil)
+			err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				return p.BatchSend(ctx, []event.Event{newMessage("1", "a"), newMessage("2", "b"), newMessage("3", "c")})
+			})
+			assert.NoError(t, err)
+
+			dst := &recordProducer{}
+			relay := NewRelay(c.store, dst, WithBatchSize(2))
+			n, err := relay.RelayOnce(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, 2, n)
+			n, err = relay.RelayOnce(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, 1, n)
+			n, err = relay.RelayOnce(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, 0, n)
+			//in order
+			assert.Equal(t, []string{"1", "2", "3"}, dst.keys())
+			assert.Equal(t, int64(3), c.count(t, "relay_once", "delivered_at IS NOT NULL"))
+			//id is kept in header
+			assert.NotEmpty(t, dst.sent[0].Header().Get(event.HeaderId))
+		})
+	}
+}
+
+func TestRelayLease(t *testing.T) {
+	for _, c := range storeCases(t, "relay_lease") {
+		t.Run(c.name, func(t *testing.T) {
+			p := NewProducer(c.store, nil)
+			assert.NoError(t, p.Send(context.Background(), newMessage("1", "a")))
+
+			leased, err := c.store.Lease(context.Background(), "a", 10, time.Minute)
+			assert.NoError(t, err)
+			assert.Len(t, leased, 1)
+			//leased by other owner
+			leased, err = c.store.Lease(context.Background(), "b", 10, time.Minute)
+			assert.NoError(t, err)
+			assert.Len(t, leased, 0)
+		})
+	}
+}
+
+func TestRelayLeaseLost(t *testing.T) {
+	for _, c := range storeCases(t, "relay_lease_lost") {
+		t.Run(c.name, func(t *testing.T) {
+			p := NewProducer(c.store, nil)
+			assert.NoError(t, p.Send(context.Background(), newMessage("1", "a")))
+
+			//lease of a expired and re-leased by b
+			leased, err := c.store.Lease(context.Background(), "a", 10, -time.Second)
+			assert.NoError(t, err)
+			assert.Len(t, leased, 1)
+			rec := leased[0]
+			leased, err = c.store.Lease(context.Background(), "b", 10, time.Minute)
+			assert.NoError(t, err)
+			assert.Len(t, leased, 1)
+
+			assert.ErrorIs(t, c.store.MarkFailed(context.Background(), "a", rec, time.Now()), ErrLeaseLost)
+			assert.ErrorIs(t, c.store.DeadLetter(context.Background(), "a", rec), ErrLeaseLost)
+			assert.ErrorIs(t, c.store.MarkDelivered(context.Background(), "a", rec.Id), ErrLeaseLost)
+			assert.Equal(t, int64(1), c.count(t, "relay_lease_lost", "lease_owner = ? AND attempts = 0", "b"))
+			assert.Equal(t, int64(0), c.count(t, "relay_lease_lost_dead_letter", "1 = 1"))
+
+			assert.NoError(t, c.store.MarkDelivered(context.Background(), "b", rec.Id))
+			//delivered records are not dead lettered
+			assert.ErrorIs(t, c.store.DeadLetter(context.Background(), "b", rec), ErrLeaseLost)
+			assert.Equal(t, int64(1), c.count(t, "relay_lease_lost", "delivered_at IS NOT NULL"))
+		})
+	}
+}
+
+func TestRelayRetryAndDeadLetter(t *testing.T) {
+	for _, c := range storeCases(t, "relay_retry") {
+		t.Run(c.name, func(t *testing.T) {
+			p := NewProducer(c.store, nil)
+			assert.NoError(t, p.Send(context.Background(), newMessage("poison", "a")))
+
+			sendErr := errors.New("broker down")
+			dst := &recordProducer{err: sendErr}
+			relay := NewRelay(c.store, dst, WithMaxAttempts(2), WithBackoff(event.ConstantBackoff(0)))
+
+			n, err := relay.RelayOnce(context.Background())
+			assert.ErrorIs(t, err, sendErr)
+			assert.Equal(t, 1, n)
+			assert.Equal(t, int64(1), c.count(t, "relay_retry", "attempts = 1 AND last_error = ?", sendErr.Error()))
+
+			n, err = relay.RelayOnce(context.Background())
+			assert.ErrorIs(t, err, sendErr)
+			assert.Equal(t, 1, n)
+			assert.Equal(t, int64(0), c.count(t, "relay_retry", "1 = 1"))
+			assert.Equal(t, int64(1), c.count(t, "relay_retry_dead_letter", "event_key = ? AND attempts = 2", "poison"))
+
+			n, err = relay.RelayOnce(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, 0, n)
+		})
+	}
+}
+
+func TestRelayStartStop(t *testing.T) {
+	for _, c := range storeCases(t, "relay_start") {
+		t.Run(c.name, func(t *testing.T) {
+			dst := &recordProducer{}
+			relay := NewRelay(c.store, dst, WithInterval(time.Hour))
+			mgr := uow.NewManager(c.factory)
+			p := NewProducer(c.store, nil, WithNotifier(relay))
+
+			started := make(chan error)
+			go func() {
+				started <- relay.Start(context.Background())
+			}()
+
+			err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				return p.Send(ctx, newMessage("notified", "a"))
+			})
+			assert.NoError(t, err)
+			assert.Eventually(t, func() bool {
+				return len(dst.keys()) == 1
+			}, time.Second, 10*time.Millisecond)
+
+			assert.NoError(t, relay.Stop(context.Background()))
+			assert.NoError(t, <-started)
+		})
+	}
+}
+
+type partialProducer struct {
+	recordProducer
+	fail string
+}
+
+func (p *partialProducer) BatchSend(ctx context.Context, msg []event.Event) error {
+	errs := make([]error, len(msg))
+	var ok []event.Event
+	for i, e := range msg {
+		if e.Key() == p.fail {
+			errs[i] = errors.New("fail " + e.Key())
+			continue
+		}
+		ok = append(ok, e)
+	}
+	_ = p.recordProducer.BatchSend(ctx, ok)
+	return event.NewBatchError(errs)
+}
+
+func TestRelayPartialFailure(t *testing.T) {
+	for _, c := range storeCases(t, "relay_partial") {
+		t.Run(c.name, func(t *testing.T) {
+			p := NewProducer(c.store, nil)
+			assert.NoError(t, p.BatchSend(context.Background(), []event.Event{newMessage("1", "a"), newMessage("2", "b"), newMessage("3", "c")}))
+
+			dst := &partialProducer{fail: "2"}
+			relay := NewRelay(c.store, dst, WithBackoff(event.ConstantBackoff(time.Hour)))
+			n, err := relay.RelayOnce(context.Background())
+			assert.Error(t, err)
+			assert.Equal(t, 3, n)
+			assert.Equal(t, []string{"1", "3"}, dst.keys())
+			assert.Equal(t, int64(2), c.count(t, "relay_partial", "delivered_at IS NOT NULL"))
+			assert.Equal(t, int64(1), c.count(t, "relay_partial", "event_key = ? AND attempts = 1", "2"))
+		})
+	}
+}
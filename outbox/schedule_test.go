@@ -0,0 +1,64 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"github.com/jace996/uow"
+	"github.com/jace996/uow/event"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	for _, c := range storeCases(t, "schedule") {
+		t.Run(c.name, func(t *testing.T) {
+			dst := &recordProducer{}
+			mgr := uow.NewManager(func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+				if len(keys) > 0 && keys[0] == "event" {
+					return event.NewTransactional(ctx, dst), nil
+				}
+				return c.factory(ctx, keys...)
+			})
+			scheduler := NewProducer(c.store, nil)
+			transP := event.NewTransactionalProducer(dst, []string{"event"}, event.WithScheduler(scheduler))
+			later := event.NewBuilder("reminder").Delay(time.Hour).Build()
+			soon := event.NewBuilder("expire").DeliverAt(time.Now().Add(50 * time.Millisecond)).Build()
+			err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				return transP.BatchSend(ctx, []event.Event{later, soon, newMessage("created", "")})
+			})
+			assert.NoError(t, err)
+			//due events are sent directly
+			assert.Equal(t, []string{"created"}, dst.keys())
+			assert.Equal(t, int64(2), c.count(t, "schedule", "delivered_at IS NULL"))
+
+			relay := NewRelay(c.store, dst)
+			n, err := relay.RelayOnce(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, 0, n)
+
+			//cancel is transactional
+			err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				if err := transP.Cancel(ctx, later.Id()); err != nil {
+					return err
+				}
+				return errors.New("fake error")
+			})
+			assert.Error(t, err)
+			assert.Equal(t, int64(1), c.count(t, "schedule", "id = ?", later.Id()))
+			err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				return transP.Cancel(ctx, later.Id())
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, int64(0), c.count(t, "schedule", "id = ?", later.Id()))
+
+			//released when due
+			time.Sleep(100 * time.Millisecond)
+			n, err = relay.RelayOnce(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, 1, n)
+			assert.Equal(t, []string{"created", "expire"}, dst.keys())
+		})
+	}
+	assert.ErrorIs(t, event.NewTransactionalProducer(&recordProducer{}, nil).Cancel(context.Background(), "1"), event.ErrNoScheduler)
+}
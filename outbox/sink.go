@@ -0,0 +1,17 @@
+package outbox
+
+import (
+	"context"
+	"github.com/jace996/uow/event"
+)
+
+// NewSink create an event.Sink which saves undeliverable events into outbox table, so they are delivered later by Relay
+func NewSink(store Store) event.Sink {
+	return event.SinkFunc(func(ctx context.Context, events []event.Event, cause error) error {
+		records := make([]*Record, len(events))
+		for i, e := range events {
+			records[i] = NewRecord(e)
+		}
+		return store.Save(ctx, nil, records...)
+	})
+}
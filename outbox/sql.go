@@ -0,0 +1,209 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"github.com/jace996/uow"
+	usql "github.com/jace996/uow/sql"
+	"strings"
+	"time"
+)
+
+// SqlStore stores outbox records with database/sql. Tables should be created in advance, e.g. in sqlite
+//
+//	CREATE TABLE outbox (
+//		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+//		id VARCHAR(64) NOT NULL UNIQUE,
+//		event_key VARCHAR(255) NOT NULL,
+//		value BLOB,
+//		headers TEXT,
+//		created_at TIMESTAMP NOT NULL,
+//		available_at TIMESTAMP NOT NULL,
+//		attempts INTEGER NOT NULL DEFAULT 0,
+//		last_error TEXT,
+//		lease_owner VARCHAR(64),
+//		lease_until TIMESTAMP,
+//		delivered_at TIMESTAMP
+//	);
+//	CREATE TABLE outbox_dead_letter (
+//		id VARCHAR(64) PRIMARY KEY,
+//		event_key VARCHAR(255) NOT NULL,
+//		value BLOB,
+//		headers TEXT,
+//		created_at TIMESTAMP NOT NULL,
+//		attempts INTEGER NOT NULL,
+//		last_error TEXT,
+//		dead_at TIMESTAMP NOT NULL
+//	);
+type SqlStore struct {
+	db          *sql.DB
+	placeholder usql.Placeholder
+	opt         *options
+}
+
+var _ RelayStore = (*SqlStore)(nil)
+
+func NewSqlStore(db *sql.DB, placeholder usql.Placeholder, opts ...Option) *SqlStore {
+	return &SqlStore{db: db, placeholder: placeholder, opt: newOptions(opts...)}
+}
+
+func (s *SqlStore) resolve(tx uow.Txn) (usql.Executor, error) {
+	if tx == nil {
+		return s.db, nil
+	}
+	t, ok := tx.(*usql.TransactionDb)
+	if !ok {
+		return nil, ErrUnsupportedTxn
+	}
+	return t, nil
+}
+
+func (s *SqlStore) Save(ctx context.Context, tx uow.Txn, records ...*Record) error {
+	db, err := s.resolve(tx)
+	if err != nil {
+		return err
+	}
+	query := s.placeholder.Rebind("INSERT INTO " + s.opt.table + " (id, event_key, value, headers, created_at, available_at, attempts) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	for _, r := range records {
+		h, err := encodeHeaders(r.Headers)
+		if err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx, query, r.Id, r.Key, r.Value, h, r.CreatedAt, r.AvailableAt, r.Attempts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SqlStore) Cancel(ctx context.Context, tx uow.Txn, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	db, err := s.resolve(tx)
+	if err != nil {
+		return err
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	query := "DELETE FROM " + s.opt.table + " WHERE delivered_at IS NULL AND id IN (?" + strings.Repeat(", ?", len(ids)-1) + ")"
+	_, err = db.ExecContext(ctx, s.placeholder.Rebind(query), args...)
+	return err
+}
+
+func (s *SqlStore) Lease(ctx context.Context, owner string, limit int, ttl time.Duration) ([]*Record, error) {
+	now := time.Now().UTC()
+	rows, err := s.db.QueryContext(ctx, s.placeholder.Rebind("SELECT id, event_key, value, headers, created_at, available_at, attempts, last_error FROM "+s.opt.table+
+		" WHERE delivered_at IS NULL AND available_at <= ? AND (lease_until IS NULL OR lease_until < ?) ORDER BY seq LIMIT ?"), now, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []*Record
+	for rows.Next() {
+		r := &Record{}
+		var h string
+		var lastErr sql.NullString
+		if err := rows.Scan(&r.Id, &r.Key, &r.Value, &h, &r.CreatedAt, &r.AvailableAt, &r.Attempts, &lastErr); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		r.LastError = lastErr.String
+		if r.Headers, err = decodeHeaders(h); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		candidates = append(candidates, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	until := now.Add(ttl)
+	claim := s.placeholder.Rebind("UPDATE " + s.opt.table + " SET lease_owner = ?, lease_until = ? WHERE id = ? AND delivered_at IS NULL AND (lease_until IS NULL OR lease_until < ?)")
+	var ret []*Record
+	for _, r := range candidates {
+		//claim the record if no other owner claimed it
+		res, err := s.db.ExecContext(ctx, claim, owner, until, r.Id, now)
+		if err != nil {
+			return ret, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return ret, err
+		} else if n != 1 {
+			continue
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+func (s *SqlStore) MarkDelivered(ctx context.Context, owner string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := []interface{}{time.Now().UTC()}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	args = append(args, owner)
+	query := "UPDATE " + s.opt.table + " SET delivered_at = ?, lease_owner = NULL, lease_until = NULL WHERE id IN (?" + strings.Repeat(", ?", len(ids)-1) + ") AND lease_owner = ? AND delivered_at IS NULL"
+	res, err := s.db.ExecContext(ctx, s.placeholder.Rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n < int64(len(ids)) {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (s *SqlStore) MarkFailed(ctx context.Context, owner string, r *Record, next time.Time) error {
+	res, err := s.db.ExecContext(ctx, s.placeholder.Rebind("UPDATE "+s.opt.table+" SET attempts = ?, last_error = ?, available_at = ?, lease_owner = NULL, lease_until = NULL WHERE id = ? AND lease_owner = ? AND delivered_at IS NULL"),
+		r.Attempts, r.LastError, next.UTC(), r.Id, owner)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (s *SqlStore) DeadLetter(ctx context.Context, owner string, r *Record) (err error) {
+	h, err := encodeHeaders(r.Headers)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	res, err := tx.ExecContext(ctx, s.placeholder.Rebind("DELETE FROM "+s.opt.table+" WHERE id = ? AND lease_owner = ? AND delivered_at IS NULL"), r.Id, owner)
+	if err != nil {
+		return err
+	}
+	var n int64
+	if n, err = res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrLeaseLost
+	}
+	_, err = tx.ExecContext(ctx, s.placeholder.Rebind("INSERT INTO "+s.opt.deadLetterTable+" (id, event_key, value, headers, created_at, attempts, last_error, dead_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"),
+		r.Id, r.Key, r.Value, h, r.CreatedAt, r.Attempts, r.LastError, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,82 @@
+package projection
+
+import (
+	"context"
+	"errors"
+	"github.com/jace996/uow"
+	ugorm "github.com/jace996/uow/gorm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type gormCheckpoint struct {
+	Name      string `gorm:"size:128;primaryKey"`
+	Position  int64
+	UpdatedAt time.Time
+}
+
+// GormStore stores checkpoints with gorm
+type GormStore struct {
+	db  *gorm.DB
+	opt *storeOptions
+}
+
+var _ CheckpointStore = (*GormStore)(nil)
+
+func NewGormStore(db *gorm.DB, opts ...StoreOption) *GormStore {
+	return &GormStore{db: db, opt: newStoreOptions(opts...)}
+}
+
+// Migrate create or update the checkpoint table
+func (s *GormStore) Migrate(ctx context.Context) error {
+	return s.db.WithContext(ctx).Table(s.opt.table).AutoMigrate(&gormCheckpoint{})
+}
+
+func (s *GormStore) resolve(tx uow.Txn) (*gorm.DB, error) {
+	if tx == nil {
+		return s.db, nil
+	}
+	t, ok := tx.(*ugorm.TransactionDb)
+	if !ok {
+		return nil, ErrUnsupportedTxn
+	}
+	return t.DB, nil
+}
+
+func (s *GormStore) Load(ctx context.Context, tx uow.Txn, name string) (int64, error) {
+	db, err := s.resolve(tx)
+	if err != nil {
+		return 0, err
+	}
+	row := &gormCheckpoint{}
+	err = db.WithContext(ctx).Table(s.opt.table).Where("name = ?", name).Take(row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return row.Position, err
+}
+
+func (s *GormStore) Save(ctx context.Context, tx uow.Txn, name string, expected, position int64) error {
+	db, err := s.resolve(tx)
+	if err != nil {
+		return err
+	}
+	db = db.WithContext(ctx)
+	now := time.Now().UTC()
+	res := db.Table(s.opt.table).Where("name = ? AND position = ?", name, expected).Updates(map[string]interface{}{"position": position, "updated_at": now})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 && expected == 0 {
+		//first checkpoint
+		res = db.Table(s.opt.table).Clauses(clause.OnConflict{DoNothing: true}).Create(&gormCheckpoint{Name: name, Position: position, UpdatedAt: now})
+		if res.Error != nil {
+			return res.Error
+		}
+	}
+	if res.RowsAffected == 0 {
+		return ErrConcurrency
+	}
+	return nil
+}
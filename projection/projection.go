@@ -0,0 +1,345 @@
+package projection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jace996/uow"
+	"github.com/jace996/uow/eventstore"
+	"github.com/jace996/uow/internal/loop"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnsupportedTxn    = errors.New("projection: unsupported transaction type")
+	ErrRunnerStarted     = errors.New("projection: runner already started")
+	ErrUnknownProjection = errors.New("projection: unknown projection")
+	ErrConcurrency       = errors.New("projection: checkpoint has been moved concurrently")
+)
+
+const (
+	DefaultTable = "projection_checkpoints"
+)
+
+// Handler applies an event to read models. It runs inside the unit of work of the batch
+type Handler func(ctx context.Context, r *eventstore.Record) error
+
+// Projection is a named set of handlers by event key
+type Projection struct {
+	name     string
+	handlers map[string][]Handler
+	any      []Handler
+	reset    func(ctx context.Context) error
+}
+
+func NewProjection(name string) *Projection {
+	return &Projection{name: name, handlers: map[string][]Handler{}}
+}
+
+func (p *Projection) Name() string {
+	return p.name
+}
+
+// On handles events of key
+func (p *Projection) On(key string, h ...Handler) *Projection {
+	p.handlers[key] = append(p.handlers[key], h...)
+	return p
+}
+
+// OnAny handles every event
+func (p *Projection) OnAny(h ...Handler) *Projection {
+	p.any = append(p.any, h...)
+	return p
+}
+
+// OnReset clears read models before rebuilding, in the same unit of work which resets the checkpoint
+func (p *Projection) OnReset(fn func(ctx context.Context) error) *Projection {
+	p.reset = fn
+	return p
+}
+
+func (p *Projection) apply(ctx context.Context, r *eventstore.Record) error {
+	for _, h := range p.handlers[r.Key] {
+		if err := h(ctx, r); err != nil {
+			return err
+		}
+	}
+	for _, h := range p.any {
+		if err := h(ctx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Source reads events of all streams in position order, e.g. *eventstore.EventStore
+type Source interface {
+	ReadAll(ctx context.Context, after int64, limit int) ([]*eventstore.Record, error)
+}
+
+// CheckpointStore persists the position of projections. tx is resolved from the unit of work
+type CheckpointStore interface {
+	Load(ctx context.Context, tx uow.Txn, name string) (int64, error)
+	// Save moves the checkpoint from expected to position. Returns ErrConcurrency if it is not at expected anymore
+	Save(ctx context.Context, tx uow.Txn, name string, expected, position int64) error
+}
+
+type storeOptions struct {
+	table string
+}
+
+type StoreOption func(*storeOptions)
+
+// WithTable change the checkpoint table name. default is DefaultTable
+func WithTable(table string) StoreOption {
+	return func(o *storeOptions) {
+		o.table = table
+	}
+}
+
+func newStoreOptions(opts ...StoreOption) *storeOptions {
+	ret := &storeOptions{table: DefaultTable}
+	for _, o := range opts {
+		o(ret)
+	}
+	return ret
+}
+
+type options struct {
+	batchSize  int
+	interval   time.Duration
+	gapTimeout time.Duration
+	errHandler func(err error)
+}
+
+type Option func(*options)
+
+// WithBatchSize change the max number of events applied in one unit of work. default 100
+func WithBatchSize(n int) Option {
+	return func(o *options) {
+		o.batchSize = n
+	}
+}
+
+// WithInterval change the polling interval. default 1s
+func WithInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.interval = d
+	}
+}
+
+// WithGapTimeout change how long a gap in positions is waited for. default 10s.
+// A gap is either an append not committed yet or a rolled back one, which never shows up
+func WithGapTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.gapTimeout = d
+	}
+}
+
+// WithErrorHandler handle errors of the run loop. default ignore
+func WithErrorHandler(f func(err error)) Option {
+	return func(o *options) {
+		o.errHandler = f
+	}
+}
+
+// Runner keeps projections up to date. Each batch is read and applied in a new unit of work
+// together with the checkpoint, so read models and checkpoint are always consistent.
+// A failed handler rolls its batch back and blocks its projection until it succeeds.
+// Multiple runners can share one checkpoint store, a batch applied concurrently by another runner is rolled back
+// Concurrent appends may commit positions out of order, so a batch stops before a gap until it is filled or WithGapTimeout passes
+type Runner struct {
+	mgr         uow.Manager
+	source      Source
+	checkpoints CheckpointStore
+	keys        []string
+	opt         *options
+
+	loop        *loop.Loop
+	mtx         sync.Mutex
+	projections map[string]*Projection
+}
+
+// NewRunner create Runner. keys resolve the transaction of checkpoints, which should be the database of read models
+func NewRunner(mgr uow.Manager, source Source, checkpoints CheckpointStore, keys []string, opts ...Option) *Runner {
+	opt := &options{
+		batchSize:  100,
+		interval:   time.Second,
+		gapTimeout: 10 * time.Second,
+		errHandler: func(err error) {},
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	r := &Runner{
+		mgr:         mgr,
+		source:      source,
+		checkpoints: checkpoints,
+		keys:        keys,
+		opt:         opt,
+		projections: map[string]*Projection{},
+	}
+	r.loop = loop.New(loop.Config{
+		Run:        r.RunOnce,
+		BatchSize:  opt.batchSize,
+		Interval:   opt.interval,
+		ErrHandler: opt.errHandler,
+		ErrStarted: ErrRunnerStarted,
+	})
+	return r
+}
+
+// Register projections
+func (r *Runner) Register(p ...*Projection) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, pp := range p {
+		r.projections[pp.name] = pp
+	}
+}
+
+func (r *Runner) all() []*Projection {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	ret := make([]*Projection, 0, len(r.projections))
+	for _, p := range r.projections {
+		ret = append(ret, p)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].name < ret[j].name })
+	return ret
+}
+
+func (r *Runner) get(name string) (*Projection, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	p, ok := r.projections[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProjection, name)
+	}
+	return p, nil
+}
+
+func (r *Runner) txn(ctx context.Context) (uow.Txn, error) {
+	u, ok := uow.FromCurrentUow(ctx)
+	if !ok {
+		return nil, uow.ErrUnitOfWorkNotFound
+	}
+	return u.GetTxDb(ctx, r.keys...)
+}
+
+// Position returns the checkpoint of projection name
+func (r *Runner) Position(ctx context.Context, name string) (int64, error) {
+	return r.checkpoints.Load(ctx, nil, name)
+}
+
+// RunOnce applies one batch to every projection. Returns the max number of events applied to a projection
+func (r *Runner) RunOnce(ctx context.Context) (int, error) {
+	var max int
+	var errs []error
+	for _, p := range r.all() {
+		n, err := r.runBatch(ctx, p)
+		if errors.Is(err, ErrConcurrency) {
+			//applied by another runner
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("projection %s: %w", p.name, err))
+		}
+		if n > max {
+			max = n
+		}
+	}
+	return max, errors.Join(errs...)
+}
+
+func (r *Runner) runBatch(ctx context.Context, p *Projection) (n int, err error) {
+	err = r.mgr.WithNew(ctx, func(ctx context.Context) error {
+		tx, err := r.txn(ctx)
+		if err != nil {
+			return err
+		}
+		pos, err := r.checkpoints.Load(ctx, tx, p.name)
+		if err != nil {
+			return err
+		}
+		records, err := r.source.ReadAll(ctx, pos, r.opt.batchSize)
+		if err != nil {
+			return err
+		}
+		records = r.untilGap(pos, records)
+		if len(records) == 0 {
+			return nil
+		}
+		for _, rec := range records {
+			if err := p.apply(ctx, rec); err != nil {
+				return err
+			}
+		}
+		n = len(records)
+		return r.checkpoints.Save(ctx, tx, p.name, pos, records[len(records)-1].Position)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// untilGap returns records before the first recent gap in positions after pos
+func (r *Runner) untilGap(pos int64, records []*eventstore.Record) []*eventstore.Record {
+	for i, rec := range records {
+		if rec.Position != pos+1 && time.Since(rec.CreatedAt) < r.opt.gapTimeout {
+			//the missing positions may still be committed
+			return records[:i]
+		}
+		pos = rec.Position
+	}
+	return records
+}
+
+// Rebuild resets read models and checkpoint of projection name, then replays all events until it is up to date
+func (r *Runner) Rebuild(ctx context.Context, name string) error {
+	p, err := r.get(name)
+	if err != nil {
+		return err
+	}
+	err = r.mgr.WithNew(ctx, func(ctx context.Context) error {
+		tx, err := r.txn(ctx)
+		if err != nil {
+			return err
+		}
+		pos, err := r.checkpoints.Load(ctx, tx, p.name)
+		if err != nil {
+			return err
+		}
+		if p.reset != nil {
+			if err := p.reset(ctx); err != nil {
+				return err
+			}
+		}
+		return r.checkpoints.Save(ctx, tx, p.name, pos, 0)
+	})
+	if err != nil {
+		return err
+	}
+	for {
+		n, err := r.runBatch(ctx, p)
+		if err != nil {
+			return err
+		}
+		if n < r.opt.batchSize {
+			return nil
+		}
+	}
+}
+
+// Start runs projections and blocks until Stop is called or ctx is done, so Runner can be used as a kratos transport.Server
+func (r *Runner) Start(ctx context.Context) error {
+	return r.loop.Start(ctx)
+}
+
+// Stop the run loop gracefully. The in-flight batch is finished unless ctx is done first
+func (r *Runner) Stop(ctx context.Context) error {
+	return r.loop.Stop(ctx)
+}
@@ -0,0 +1,302 @@
+package projection
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jace996/uow"
+	"github.com/jace996/uow/event"
+	"github.com/jace996/uow/eventstore"
+	ugorm "github.com/jace996/uow/gorm"
+	usql "github.com/jace996/uow/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"os"
+	"testing"
+	"time"
+)
+
+const sqlSchema = `CREATE TABLE %[1]s_events (
+	position INTEGER PRIMARY KEY AUTOINCREMENT,
+	stream_id VARCHAR(255) NOT NULL,
+	version INTEGER NOT NULL,
+	event_key VARCHAR(255) NOT NULL,
+	value BLOB,
+	headers TEXT,
+	created_at TIMESTAMP NOT NULL,
+	UNIQUE (stream_id, version)
+);
+CREATE TABLE %[1]s_checkpoints (
+	name VARCHAR(128) PRIMARY KEY,
+	position INTEGER NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+CREATE TABLE %[1]s_counts (
+	stream_id VARCHAR(255) PRIMARY KEY,
+	count INTEGER NOT NULL
+)`
+
+var (
+	gormClient *gorm.DB
+	sqlClient  *sql.DB
+)
+
+func TestMain(m *testing.M) {
+	var err error
+	gormClient, err = gorm.Open(sqlite.Open("file:projection_gorm.DB?cache=shared&mode=memory"), &gorm.Config{SkipDefaultTransaction: true})
+	if err != nil {
+		panic(err)
+	}
+	db, _ := gormClient.DB()
+	db.SetMaxOpenConns(1)
+
+	sqlClient, err = sql.Open("sqlite3", "file:projection_sql.DB?cache=shared&mode=memory")
+	if err != nil {
+		panic(err)
+	}
+	sqlClient.SetMaxOpenConns(1)
+	exitCode := m.Run()
+	os.Exit(exitCode)
+}
+
+type storeCase struct {
+	name        string
+	events      eventstore.Store
+	checkpoints CheckpointStore
+	factory     uow.DbFactory
+	// exec runs query with the transaction of current unit of work
+	exec  func(ctx context.Context, query string, args ...interface{}) error
+	count func(t *testing.T, query string, args ...interface{}) int64
+}
+
+func storeCases(t *testing.T, prefix string) []storeCase {
+	gs := eventstore.NewGormStore(gormClient, eventstore.WithTable(prefix+"_events"))
+	assert.NoError(t, gs.Migrate(context.Background()))
+	gc := NewGormStore(gormClient, WithTable(prefix+"_checkpoints"))
+	assert.NoError(t, gc.Migrate(context.Background()))
+	assert.NoError(t, gormClient.Exec("CREATE TABLE "+prefix+"_counts (stream_id VARCHAR(255) PRIMARY KEY, count INTEGER NOT NULL)").Error)
+	_, err := sqlClient.Exec(fmt.Sprintf(sqlSchema, prefix))
+	assert.NoError(t, err)
+	return []storeCase{
+		{
+			name:        "gorm",
+			events:      gs,
+			checkpoints: gc,
+			factory: func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+				return ugorm.NewTransactionDb(gormClient), nil
+			},
+			exec: func(ctx context.Context, query string, args ...interface{}) error {
+				u, _ := uow.FromCurrentUow(ctx)
+				tx, err := u.GetTxDb(ctx)
+				if err != nil {
+					return err
+				}
+				return tx.(*ugorm.TransactionDb).Exec(query, args...).Error
+			},
+			count: func(t *testing.T, query string, args ...interface{}) int64 {
+				var n int64
+				assert.NoError(t, gormClient.Raw(query, args...).Scan(&n).Error)
+				return n
+			},
+		},
+		{
+			name:        "sql",
+			events:      eventstore.NewSqlStore(sqlClient, usql.Question, eventstore.WithTable(prefix+"_events")),
+			checkpoints: NewSqlStore(sqlClient, usql.Question, WithTable(prefix+"_checkpoints")),
+			factory: func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+				return usql.NewTransactionDb(sqlClient), nil
+			},
+			exec: func(ctx context.Context, query string, args ...interface{}) error {
+				u, _ := uow.FromCurrentUow(ctx)
+				tx, err := u.GetTxDb(ctx)
+				if err != nil {
+					return err
+				}
+				_, err = tx.(*usql.TransactionDb).ExecContext(ctx, query, args...)
+				return err
+			},
+			count: func(t *testing.T, query string, args ...interface{}) int64 {
+				var n int64
+				assert.NoError(t, sqlClient.QueryRow(query, args...).Scan(&n))
+				return n
+			},
+		},
+	}
+}
+
+func countProjection(c storeCase, table string) *Projection {
+	return NewProjection("counts").
+		On("created", func(ctx context.Context, r *eventstore.Record) error {
+			return c.exec(ctx, "INSERT INTO "+table+" (stream_id, count) VALUES (?, 1)", r.StreamId)
+		}).
+		On("updated", func(ctx context.Context, r *eventstore.Record) error {
+			return c.exec(ctx, "UPDATE "+table+" SET count = count + 1 WHERE stream_id = ?", r.StreamId)
+		}).
+		OnReset(func(ctx context.Context) error {
+			return c.exec(ctx, "DELETE FROM "+table)
+		})
+}
+
+func TestRunner(t *testing.T) {
+	for _, c := range storeCases(t, "runner") {
+		t.Run(c.name, func(t *testing.T) {
+			mgr := uow.NewManager(c.factory)
+			es := eventstore.New(c.events, nil)
+			err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				if _, err := es.Append(ctx, "order-1", eventstore.NoStream,
+					event.NewMessage("created", nil), event.NewMessage("updated", nil), event.NewMessage("updated", nil)); err != nil {
+					return err
+				}
+				_, err := es.Append(ctx, "order-2", eventstore.NoStream, event.NewMessage("created", nil))
+				return err
+			})
+			assert.NoError(t, err)
+
+			var seen []string
+			audit := NewProjection("audit").OnAny(func(ctx context.Context, r *eventstore.Record) error {
+				seen = append(seen, fmt.Sprintf("%s@%d", r.StreamId, r.Version))
+				return nil
+			})
+			runner := NewRunner(mgr, es, c.checkpoints, nil, WithBatchSize(3))
+			runner.Register(countProjection(c, "runner_counts"), audit)
+
+			n, err := runner.RunOnce(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, 3, n)
+			n, err = runner.RunOnce(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, 1, n)
+			n, err = runner.RunOnce(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, 0, n)
+
+			assert.Equal(t, []string{"order-1@1", "order-1@2", "order-1@3", "order-2@1"}, seen)
+			assert.Equal(t, int64(3), c.count(t, "SELECT count FROM runner_counts WHERE stream_id = ?", "order-1"))
+			assert.Equal(t, int64(1), c.count(t, "SELECT count FROM runner_counts WHERE stream_id = ?", "order-2"))
+			all, err := es.ReadAll(context.Background(), 0, 10)
+			assert.NoError(t, err)
+			pos, err := runner.Position(context.Background(), "counts")
+			assert.NoError(t, err)
+			assert.Equal(t, all[len(all)-1].Position, pos)
+
+			//rebuild from zero
+			assert.NoError(t, runner.Rebuild(context.Background(), "counts"))
+			assert.Equal(t, int64(3), c.count(t, "SELECT count FROM runner_counts WHERE stream_id = ?", "order-1"))
+			pos, err = runner.Position(context.Background(), "counts")
+			assert.NoError(t, err)
+			assert.Equal(t, all[len(all)-1].Position, pos)
+			assert.ErrorIs(t, runner.Rebuild(context.Background(), "unknown"), ErrUnknownProjection)
+		})
+	}
+}
+
+func TestRunnerFailure(t *testing.T) {
+	for _, c := range storeCases(t, "failure") {
+		t.Run(c.name, func(t *testing.T) {
+			mgr := uow.NewManager(c.factory)
+			es := eventstore.New(c.events, nil)
+			err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				_, err := es.Append(ctx, "order-1", eventstore.NoStream, event.NewMessage("created", nil), event.NewMessage("shipped", nil))
+				return err
+			})
+			assert.NoError(t, err)
+
+			fail := errors.New("fake error")
+			p := countProjection(c, "failure_counts").On("shipped", func(ctx context.Context, r *eventstore.Record) error {
+				return fail
+			})
+			runner := NewRunner(mgr, es, c.checkpoints, nil)
+			runner.Register(p)
+			_, err = runner.RunOnce(context.Background())
+			assert.ErrorIs(t, err, fail)
+			//batch rolled back with checkpoint
+			assert.Equal(t, int64(0), c.count(t, "SELECT COUNT(*) FROM failure_counts"))
+			pos, err := runner.Position(context.Background(), "counts")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(0), pos)
+		})
+	}
+}
+
+func TestRunnerStartStop(t *testing.T) {
+	c := storeCases(t, "start")[0]
+	mgr := uow.NewManager(c.factory)
+	es := eventstore.New(c.events, nil)
+	runner := NewRunner(mgr, es, c.checkpoints, nil, WithInterval(time.Millisecond))
+	runner.Register(countProjection(c, "start_counts"))
+	go runner.Start(context.Background())
+
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		_, err := es.Append(ctx, "order-1", eventstore.NoStream, event.NewMessage("created", nil))
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return c.count(t, "SELECT COUNT(*) FROM start_counts") == 1
+	}, time.Second, 5*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, runner.Stop(ctx))
+}
+
+type fakeSource []*eventstore.Record
+
+func (s fakeSource) ReadAll(ctx context.Context, after int64, limit int) ([]*eventstore.Record, error) {
+	var ret []*eventstore.Record
+	for _, r := range s {
+		if r.Position > after && len(ret) < limit {
+			ret = append(ret, r)
+		}
+	}
+	return ret, nil
+}
+
+func TestRunnerGap(t *testing.T) {
+	c := storeCases(t, "gap")[0]
+	var seen []int64
+	p := NewProjection("gap").OnAny(func(ctx context.Context, r *eventstore.Record) error {
+		seen = append(seen, r.Position)
+		return nil
+	})
+	//position 2 is not committed yet
+	now := time.Now()
+	source := fakeSource{
+		{Position: 1, Key: "created", CreatedAt: now},
+		{Position: 3, Key: "created", CreatedAt: now},
+	}
+	runner := NewRunner(uow.NewManager(c.factory), source, c.checkpoints, nil, WithGapTimeout(50*time.Millisecond))
+	runner.Register(p)
+	n, err := runner.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = runner.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	//rolled back, never shows up
+	time.Sleep(50 * time.Millisecond)
+	n, err = runner.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []int64{1, 3}, seen)
+}
+
+func TestCheckpointConcurrency(t *testing.T) {
+	for _, c := range storeCases(t, "concurrency") {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			assert.NoError(t, c.checkpoints.Save(ctx, nil, "counts", 0, 3))
+			//another runner saved the first checkpoint
+			assert.ErrorIs(t, c.checkpoints.Save(ctx, nil, "counts", 0, 2), ErrConcurrency)
+			assert.NoError(t, c.checkpoints.Save(ctx, nil, "counts", 3, 5))
+			//another runner applied the same batch
+			assert.ErrorIs(t, c.checkpoints.Save(ctx, nil, "counts", 3, 5), ErrConcurrency)
+			pos, err := c.checkpoints.Load(ctx, nil, "counts")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(5), pos)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package projection
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jace996/uow"
+	usql "github.com/jace996/uow/sql"
+	"time"
+)
+
+// SqlStore stores checkpoints with database/sql. The first checkpoint is inserted with ON CONFLICT DO NOTHING,
+// so the database should support it, e.g. postgres or sqlite. The table should be created in advance, e.g.
+//
+//	CREATE TABLE projection_checkpoints (
+//		name VARCHAR(128) PRIMARY KEY,
+//		position INTEGER NOT NULL,
+//		updated_at TIMESTAMP NOT NULL
+//	);
+type SqlStore struct {
+	db          *sql.DB
+	placeholder usql.Placeholder
+	opt         *storeOptions
+}
+
+var _ CheckpointStore = (*SqlStore)(nil)
+
+func NewSqlStore(db *sql.DB, placeholder usql.Placeholder, opts ...StoreOption) *SqlStore {
+	return &SqlStore{db: db, placeholder: placeholder, opt: newStoreOptions(opts...)}
+}
+
+func (s *SqlStore) resolve(tx uow.Txn) (usql.Executor, error) {
+	if tx == nil {
+		return s.db, nil
+	}
+	t, ok := tx.(*usql.TransactionDb)
+	if !ok {
+		return nil, ErrUnsupportedTxn
+	}
+	return t, nil
+}
+
+func (s *SqlStore) Load(ctx context.Context, tx uow.Txn, name string) (int64, error) {
+	db, err := s.resolve(tx)
+	if err != nil {
+		return 0, err
+	}
+	var pos int64
+	err = db.QueryRowContext(ctx, s.placeholder.Rebind("SELECT position FROM "+s.opt.table+" WHERE name = ?"), name).Scan(&pos)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return pos, err
+}
+
+func (s *SqlStore) Save(ctx context.Context, tx uow.Txn, name string, expected, position int64) error {
+	db, err := s.resolve(tx)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	res, err := db.ExecContext(ctx, s.placeholder.Rebind("UPDATE "+s.opt.table+" SET position = ?, updated_at = ? WHERE name = ? AND position = ?"), position, now, name, expected)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 && expected == 0 {
+		//first checkpoint
+		res, err = db.ExecContext(ctx, s.placeholder.Rebind("INSERT INTO "+s.opt.table+" (name, position, updated_at) VALUES (?, ?, ?) ON CONFLICT (name) DO NOTHING"), name, position, now)
+		if err != nil {
+			return err
+		}
+		if n, err = res.RowsAffected(); err != nil {
+			return err
+		}
+	}
+	if n == 0 {
+		return ErrConcurrency
+	}
+	return nil
+}
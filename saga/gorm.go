@@ -0,0 +1,173 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"github.com/jace996/uow"
+	ugorm "github.com/jace996/uow/gorm"
+	"gorm.io/gorm"
+	"time"
+)
+
+type gormInstance struct {
+	Id         string `gorm:"size:64;primaryKey"`
+	Name       string `gorm:"size:255"`
+	Status     string `gorm:"size:32;index"`
+	Step       int
+	Data       []byte
+	LastError  string
+	Version    int64
+	LeaseOwner *string `gorm:"size:64"`
+	LeaseUntil *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func newGormInstance(i *Instance) *gormInstance {
+	ret := &gormInstance{
+		Id:        i.Id,
+		Name:      i.Name,
+		Status:    string(i.Status),
+		Step:      i.Step,
+		Data:      i.Data,
+		LastError: i.LastError,
+		Version:   i.Version,
+		CreatedAt: i.CreatedAt,
+		UpdatedAt: i.UpdatedAt,
+	}
+	if len(i.LeaseOwner) > 0 {
+		ret.LeaseOwner = &i.LeaseOwner
+	}
+	if !i.LeaseUntil.IsZero() {
+		ret.LeaseUntil = &i.LeaseUntil
+	}
+	return ret
+}
+
+func (g *gormInstance) toInstance() *Instance {
+	ret := &Instance{
+		Id:        g.Id,
+		Name:      g.Name,
+		Status:    Status(g.Status),
+		Step:      g.Step,
+		Data:      g.Data,
+		LastError: g.LastError,
+		Version:   g.Version,
+		CreatedAt: g.CreatedAt,
+		UpdatedAt: g.UpdatedAt,
+	}
+	if g.LeaseOwner != nil {
+		ret.LeaseOwner = *g.LeaseOwner
+	}
+	if g.LeaseUntil != nil {
+		ret.LeaseUntil = *g.LeaseUntil
+	}
+	return ret
+}
+
+// GormStore stores saga instances with gorm
+type GormStore struct {
+	db  *gorm.DB
+	opt *storeOptions
+}
+
+var _ Store = (*GormStore)(nil)
+
+func NewGormStore(db *gorm.DB, opts ...StoreOption) *GormStore {
+	return &GormStore{db: db, opt: newStoreOptions(opts...)}
+}
+
+// Migrate create or update saga table
+func (s *GormStore) Migrate(ctx context.Context) error {
+	return s.db.WithContext(ctx).Table(s.opt.table).AutoMigrate(&gormInstance{})
+}
+
+func (s *GormStore) resolve(tx uow.Txn) (*gorm.DB, error) {
+	if tx == nil {
+		return s.db, nil
+	}
+	t, ok := tx.(*ugorm.TransactionDb)
+	if !ok {
+		return nil, ErrUnsupportedTxn
+	}
+	return t.DB, nil
+}
+
+func (s *GormStore) Insert(ctx context.Context, tx uow.Txn, i *Instance) error {
+	db, err := s.resolve(tx)
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Table(s.opt.table).Create(newGormInstance(i)).Error
+}
+
+func (s *GormStore) Update(ctx context.Context, tx uow.Txn, i *Instance, expected int64) error {
+	db, err := s.resolve(tx)
+	if err != nil {
+		return err
+	}
+	row := newGormInstance(i)
+	res := db.WithContext(ctx).Table(s.opt.table).Where("id = ? AND version = ?", i.Id, expected).
+		Updates(map[string]interface{}{
+			"status":      row.Status,
+			"step":        row.Step,
+			"data":        row.Data,
+			"last_error":  row.LastError,
+			"version":     row.Version,
+			"lease_owner": row.LeaseOwner,
+			"lease_until": row.LeaseUntil,
+			"updated_at":  row.UpdatedAt,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		return ErrConcurrency
+	}
+	return nil
+}
+
+func (s *GormStore) Get(ctx context.Context, tx uow.Txn, id string) (*Instance, error) {
+	db, err := s.resolve(tx)
+	if err != nil {
+		return nil, err
+	}
+	row := &gormInstance{}
+	err = db.WithContext(ctx).Table(s.opt.table).Where("id = ?", id).Take(row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.toInstance(), nil
+}
+
+func (s *GormStore) Lease(ctx context.Context, owner string, limit int, ttl time.Duration) ([]*Instance, error) {
+	now := time.Now().UTC()
+	var rows []*gormInstance
+	err := s.db.WithContext(ctx).Table(s.opt.table).
+		Where("status IN ? AND (lease_until IS NULL OR lease_until < ?)", []string{string(StatusRunning), string(StatusCompensating)}, now).
+		Order("created_at").Limit(limit).Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	until := now.Add(ttl)
+	var ret []*Instance
+	for _, row := range rows {
+		//claim the instance if no other owner claimed or updated it
+		res := s.db.WithContext(ctx).Table(s.opt.table).
+			Where("id = ? AND version = ?", row.Id, row.Version).
+			Updates(map[string]interface{}{"lease_owner": owner, "lease_until": until, "version": row.Version + 1})
+		if res.Error != nil {
+			return ret, res.Error
+		}
+		if res.RowsAffected != 1 {
+			continue
+		}
+		i := row.toInstance()
+		i.Version, i.LeaseOwner, i.LeaseUntil = row.Version+1, owner, until
+		ret = append(ret, i)
+	}
+	return ret, nil
+}
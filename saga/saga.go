@@ -0,0 +1,452 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jace996/uow"
+	"github.com/jace996/uow/internal/loop"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnsupportedTxn      = errors.New("saga: unsupported transaction type")
+	ErrNotFound            = errors.New("saga: instance not found")
+	ErrConcurrency         = errors.New("saga: instance has been modified concurrently")
+	ErrUnknownSaga         = errors.New("saga: unknown saga")
+	ErrCompensated         = errors.New("saga: compensated")
+	ErrOrchestratorStarted = errors.New("saga: orchestrator already started")
+)
+
+const (
+	DefaultTable = "sagas"
+)
+
+type Status string
+
+const (
+	// StatusRunning actions are executing. Step is the number of completed steps
+	StatusRunning Status = "running"
+	// StatusCompensating compensations are executing. Step is the number of steps left to compensate
+	StatusCompensating Status = "compensating"
+	StatusCompleted    Status = "completed"
+	StatusCompensated  Status = "compensated"
+)
+
+// Done returns true if the saga will not execute anymore
+func (s Status) Done() bool {
+	return s == StatusCompleted || s == StatusCompensated
+}
+
+// Instance is the persisted state of one saga execution
+type Instance struct {
+	Id     string
+	Name   string
+	Status Status
+	Step   int
+	// Data is shared by steps. Replace it instead of modifying in place, it is persisted with the state of each step
+	Data      []byte
+	LastError string
+	// Version is increased by every update for optimistic concurrency
+	Version    int64
+	LeaseOwner string
+	LeaseUntil time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// StepFunc runs inside the unit of work of the step
+type StepFunc func(ctx context.Context, i *Instance) error
+
+// Step of a saga. Action and Compensate may be retried after crash, so external calls should be idempotent
+type Step struct {
+	Name       string
+	Action     StepFunc
+	Compensate StepFunc
+}
+
+// Saga is a named sequence of steps
+type Saga struct {
+	name  string
+	steps []Step
+}
+
+func New(name string, steps ...Step) *Saga {
+	return &Saga{name: name, steps: steps}
+}
+
+func (s *Saga) Name() string {
+	return s.name
+}
+
+// Step appends a step. compensate can be nil if the action needs no compensation
+func (s *Saga) Step(name string, action, compensate StepFunc) *Saga {
+	s.steps = append(s.steps, Step{Name: name, Action: action, Compensate: compensate})
+	return s
+}
+
+// Store persists saga instances
+type Store interface {
+	// Insert i with tx resolved from the unit of work. tx is nil when called outside a unit of work
+	Insert(ctx context.Context, tx uow.Txn, i *Instance) error
+	// Update i if the stored version equals expected, otherwise returns ErrConcurrency
+	Update(ctx context.Context, tx uow.Txn, i *Instance, expected int64) error
+	// Get returns ErrNotFound if id does not exist
+	Get(ctx context.Context, tx uow.Txn, id string) (*Instance, error)
+	// Lease claims at most limit running or compensating instances for owner until ttl expires. Leased instances are invisible to other owners
+	Lease(ctx context.Context, owner string, limit int, ttl time.Duration) ([]*Instance, error)
+}
+
+type storeOptions struct {
+	table string
+}
+
+type StoreOption func(*storeOptions)
+
+// WithTable change the saga table name. default is DefaultTable
+func WithTable(table string) StoreOption {
+	return func(o *storeOptions) {
+		o.table = table
+	}
+}
+
+func newStoreOptions(opts ...StoreOption) *storeOptions {
+	ret := &storeOptions{table: DefaultTable}
+	for _, o := range opts {
+		o(ret)
+	}
+	return ret
+}
+
+type options struct {
+	owner      string
+	batchSize  int
+	interval   time.Duration
+	leaseTTL   time.Duration
+	errHandler func(err error)
+}
+
+type Option func(*options)
+
+// WithOwner change the lease owner of this orchestrator instance. default is a random uuid
+func WithOwner(owner string) Option {
+	return func(o *options) {
+		o.owner = owner
+	}
+}
+
+// WithBatchSize change the max number of instances resumed by RunOnce. default 100
+func WithBatchSize(n int) Option {
+	return func(o *options) {
+		o.batchSize = n
+	}
+}
+
+// WithInterval change the polling interval. default 1s
+func WithInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.interval = d
+	}
+}
+
+// WithLeaseTTL change how long an executing instance is invisible to other orchestrators. It is renewed by every step,
+// so it should be longer than the slowest step. default 30s
+func WithLeaseTTL(d time.Duration) Option {
+	return func(o *options) {
+		o.leaseTTL = d
+	}
+}
+
+// WithErrorHandler handle errors of the run loop. default ignore
+func WithErrorHandler(f func(err error)) Option {
+	return func(o *options) {
+		o.errHandler = f
+	}
+}
+
+// Orchestrator executes sagas. Each step runs in a new unit of work together with the update of the instance,
+// so the state always matches the committed steps. A failed action is rolled back and completed steps are compensated in reverse order.
+// A failed compensation is retried by the run loop until it succeeds.
+// Multiple orchestrators can share one store, instances are leased to avoid concurrent execution
+type Orchestrator struct {
+	mgr   uow.Manager
+	store Store
+	keys  []string
+	opt   *options
+	loop  *loop.Loop
+
+	mtx   sync.Mutex
+	sagas map[string]*Saga
+}
+
+// NewOrchestrator create Orchestrator. keys resolve the transaction of store, which should be the database written by steps
+func NewOrchestrator(mgr uow.Manager, store Store, keys []string, opts ...Option) *Orchestrator {
+	opt := &options{
+		owner:      uuid.New().String(),
+		batchSize:  100,
+		interval:   time.Second,
+		leaseTTL:   30 * time.Second,
+		errHandler: func(err error) {},
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	ret := &Orchestrator{
+		mgr:   mgr,
+		store: store,
+		keys:  keys,
+		opt:   opt,
+		sagas: map[string]*Saga{},
+	}
+	ret.loop = loop.New(loop.Config{
+		Run:        ret.RunOnce,
+		BatchSize:  opt.batchSize,
+		Interval:   opt.interval,
+		ErrHandler: opt.errHandler,
+		ErrStarted: ErrOrchestratorStarted,
+	})
+	return ret
+}
+
+// Register sagas
+func (o *Orchestrator) Register(s ...*Saga) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	for _, ss := range s {
+		o.sagas[ss.name] = ss
+	}
+}
+
+func (o *Orchestrator) get(name string) (*Saga, error) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	s, ok := o.sagas[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSaga, name)
+	}
+	return s, nil
+}
+
+func newInstance(name string, data []byte) *Instance {
+	now := time.Now().UTC()
+	return &Instance{
+		Id:        uuid.New().String(),
+		Name:      name,
+		Status:    StatusRunning,
+		Data:      data,
+		Version:   1,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// Create persists a new instance of saga name without executing it. Inside a unit of work the instance is inserted with it,
+// so the saga starts only if the unit of work commits. It is executed by the run loop
+func (o *Orchestrator) Create(ctx context.Context, name string, data []byte) (*Instance, error) {
+	if _, err := o.get(name); err != nil {
+		return nil, err
+	}
+	i := newInstance(name, data)
+	var tx uow.Txn
+	if u, ok := uow.FromCurrentUow(ctx); ok {
+		var err error
+		if tx, err = u.GetTxDb(ctx, o.keys...); err != nil {
+			return nil, err
+		}
+	}
+	if err := o.store.Insert(ctx, tx, i); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// Execute creates and executes an instance of saga name until it is done. Returns an error wrapping ErrCompensated and the failure if the saga is compensated.
+// If other errors are returned, e.g. a failed compensation, the instance is resumed by the run loop
+func (o *Orchestrator) Execute(ctx context.Context, name string, data []byte) (*Instance, error) {
+	s, err := o.get(name)
+	if err != nil {
+		return nil, err
+	}
+	i := newInstance(name, data)
+	i.LeaseOwner = o.opt.owner
+	i.LeaseUntil = i.CreatedAt.Add(o.opt.leaseTTL)
+	if err := o.store.Insert(ctx, nil, i); err != nil {
+		return nil, err
+	}
+	return o.run(ctx, s, i)
+}
+
+// Get returns the instance of id
+func (o *Orchestrator) Get(ctx context.Context, id string) (*Instance, error) {
+	return o.store.Get(ctx, nil, id)
+}
+
+type actionError struct {
+	err error
+}
+
+func (e *actionError) Error() string {
+	return e.err.Error()
+}
+
+func (o *Orchestrator) run(ctx context.Context, s *Saga, i *Instance) (*Instance, error) {
+	var failure error
+	for {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		switch {
+		case i.Status == StatusCompleted:
+			return i, nil
+		case i.Status == StatusCompensated:
+			if failure == nil {
+				failure = errors.New(i.LastError)
+			}
+			return i, fmt.Errorf("%w: %w", ErrCompensated, failure)
+		case i.Step < 0 || i.Step > len(s.steps):
+			return i, fmt.Errorf("saga %s: step %d out of range", s.name, i.Step)
+		case i.Status == StatusRunning:
+			if i.Step == len(s.steps) {
+				//steps removed from the saga after the instance is created
+				next, err := o.transition(ctx, i, func(ctx context.Context, next *Instance) error {
+					next.Status = StatusCompleted
+					return nil
+				})
+				if err != nil {
+					return i, err
+				}
+				i = next
+				continue
+			}
+			step := s.steps[i.Step]
+			next, err := o.transition(ctx, i, func(ctx context.Context, next *Instance) error {
+				if err := step.Action(ctx, next); err != nil {
+					return &actionError{err: err}
+				}
+				next.Step++
+				if next.Step == len(s.steps) {
+					next.Status = StatusCompleted
+				}
+				return nil
+			})
+			var ae *actionError
+			if errors.As(err, &ae) {
+				//the failed step is rolled back, only completed steps are compensated
+				failure = fmt.Errorf("saga %s step %s: %w", s.name, step.Name, ae.err)
+				next, err = o.transition(ctx, i, func(ctx context.Context, next *Instance) error {
+					next.Status = StatusCompensating
+					if next.Step == 0 {
+						next.Status = StatusCompensated
+					}
+					next.LastError = failure.Error()
+					return nil
+				})
+			}
+			if err != nil {
+				return i, err
+			}
+			i = next
+		case i.Status == StatusCompensating:
+			if i.Step == 0 {
+				next, err := o.transition(ctx, i, func(ctx context.Context, next *Instance) error {
+					next.Status = StatusCompensated
+					return nil
+				})
+				if err != nil {
+					return i, err
+				}
+				i = next
+				continue
+			}
+			step := s.steps[i.Step-1]
+			next, err := o.transition(ctx, i, func(ctx context.Context, next *Instance) error {
+				if step.Compensate != nil {
+					if err := step.Compensate(ctx, next); err != nil {
+						return err
+					}
+				}
+				next.Step--
+				if next.Step == 0 {
+					next.Status = StatusCompensated
+				}
+				return nil
+			})
+			if err != nil {
+				return i, fmt.Errorf("saga %s compensate %s: %w", s.name, step.Name, err)
+			}
+			i = next
+		default:
+			return i, fmt.Errorf("saga %s: unknown status %s", s.name, i.Status)
+		}
+	}
+}
+
+// transition runs fn and updates the instance in a new unit of work. i is not modified if anything fails
+func (o *Orchestrator) transition(ctx context.Context, i *Instance, fn func(ctx context.Context, next *Instance) error) (*Instance, error) {
+	next := *i
+	err := o.mgr.WithNew(ctx, func(ctx context.Context) error {
+		if err := fn(ctx, &next); err != nil {
+			return err
+		}
+		u, _ := uow.FromCurrentUow(ctx)
+		tx, err := u.GetTxDb(ctx, o.keys...)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		next.Version = i.Version + 1
+		next.UpdatedAt = now
+		if next.Status.Done() {
+			next.LeaseOwner, next.LeaseUntil = "", time.Time{}
+		} else {
+			//renew the lease
+			next.LeaseOwner, next.LeaseUntil = o.opt.owner, now.Add(o.opt.leaseTTL)
+		}
+		return o.store.Update(ctx, tx, &next, i.Version)
+	})
+	if err != nil {
+		return i, err
+	}
+	return &next, nil
+}
+
+// RunOnce resumes at most batch size in-flight instances, e.g. created by Create or interrupted by a restart.
+// Instances are leased one at a time right before running, so a lease never expires while waiting for previous ones.
+// Returns the number of leased instances
+func (o *Orchestrator) RunOnce(ctx context.Context) (int, error) {
+	var n int
+	var errs []error
+	for n < o.opt.batchSize {
+		instances, err := o.store.Lease(ctx, o.opt.owner, 1, o.opt.leaseTTL)
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+		if len(instances) == 0 {
+			break
+		}
+		n++
+		i := instances[0]
+		s, err := o.get(i.Name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, err := o.run(ctx, s, i); err != nil && !errors.Is(err, ErrCompensated) {
+			errs = append(errs, fmt.Errorf("saga %s: %w", i.Id, err))
+		}
+	}
+	return n, errors.Join(errs...)
+}
+
+// Start resumes instances and blocks until Stop is called or ctx is done, so Orchestrator can be used as a kratos transport.Server
+func (o *Orchestrator) Start(ctx context.Context) error {
+	return o.loop.Start(ctx)
+}
+
+// Stop the run loop gracefully. The in-flight batch is finished unless ctx is done first
+func (o *Orchestrator) Stop(ctx context.Context) error {
+	return o.loop.Stop(ctx)
+}
@@ -0,0 +1,315 @@
+package saga
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jace996/uow"
+	ugorm "github.com/jace996/uow/gorm"
+	usql "github.com/jace996/uow/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"os"
+	"testing"
+	"time"
+)
+
+const sqlSchema = `CREATE TABLE %[1]s (
+	id VARCHAR(64) PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	status VARCHAR(32) NOT NULL,
+	step INTEGER NOT NULL,
+	data BLOB,
+	last_error TEXT,
+	version INTEGER NOT NULL,
+	lease_owner VARCHAR(64),
+	lease_until TIMESTAMP,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+CREATE TABLE %[1]s_log (
+	entry VARCHAR(255) NOT NULL
+)`
+
+var (
+	gormClient *gorm.DB
+	sqlClient  *sql.DB
+)
+
+func TestMain(m *testing.M) {
+	var err error
+	gormClient, err = gorm.Open(sqlite.Open("file:saga_gorm.DB?cache=shared&mode=memory"), &gorm.Config{SkipDefaultTransaction: true})
+	if err != nil {
+		panic(err)
+	}
+	db, _ := gormClient.DB()
+	db.SetMaxOpenConns(1)
+
+	sqlClient, err = sql.Open("sqlite3", "file:saga_sql.DB?cache=shared&mode=memory")
+	if err != nil {
+		panic(err)
+	}
+	sqlClient.SetMaxOpenConns(1)
+	exitCode := m.Run()
+	os.Exit(exitCode)
+}
+
+type storeCase struct {
+	name    string
+	store   Store
+	factory uow.DbFactory
+	// write appends entry to the log table with the transaction of current unit of work
+	write func(ctx context.Context, entry string) error
+	log   func(t *testing.T) []string
+}
+
+func storeCases(t *testing.T, table string) []storeCase {
+	gs := NewGormStore(gormClient, WithTable(table))
+	assert.NoError(t, gs.Migrate(context.Background()))
+	assert.NoError(t, gormClient.Exec("CREATE TABLE "+table+"_log (entry VARCHAR(255) NOT NULL)").Error)
+	_, err := sqlClient.Exec(fmt.Sprintf(sqlSchema, table))
+	assert.NoError(t, err)
+	return []storeCase{
+		{
+			name:  "gorm",
+			store: gs,
+			factory: func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+				return ugorm.NewTransactionDb(gormClient), nil
+			},
+			write: func(ctx context.Context, entry string) error {
+				u, _ := uow.FromCurrentUow(ctx)
+				tx, err := u.GetTxDb(ctx)
+				if err != nil {
+					return err
+				}
+				return tx.(*ugorm.TransactionDb).Exec("INSERT INTO "+table+"_log (entry) VALUES (?)", entry).Error
+			},
+			log: func(t *testing.T) []string {
+				var ret []string
+				assert.NoError(t, gormClient.Raw("SELECT entry FROM "+table+"_log ORDER BY rowid").Scan(&ret).Error)
+				return ret
+			},
+		},
+		{
+			name:  "sql",
+			store: NewSqlStore(sqlClient, usql.Question, WithTable(table)),
+			factory: func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+				return usql.NewTransactionDb(sqlClient), nil
+			},
+			write: func(ctx context.Context, entry string) error {
+				u, _ := uow.FromCurrentUow(ctx)
+				tx, err := u.GetTxDb(ctx)
+				if err != nil {
+					return err
+				}
+				_, err = tx.(*usql.TransactionDb).ExecContext(ctx, "INSERT INTO "+table+"_log (entry) VALUES (?)", entry)
+				return err
+			},
+			log: func(t *testing.T) []string {
+				rows, err := sqlClient.Query("SELECT entry FROM " + table + "_log ORDER BY rowid")
+				assert.NoError(t, err)
+				defer rows.Close()
+				var ret []string
+				for rows.Next() {
+					var e string
+					assert.NoError(t, rows.Scan(&e))
+					ret = append(ret, e)
+				}
+				return ret
+			},
+		},
+	}
+}
+
+// order saga writes "do <step>" and "undo <step>" into the log table. fail makes the action of step fail
+func orderSaga(c storeCase, fail map[string]error) *Saga {
+	s := New("order")
+	for _, name := range []string{"reserve", "charge", "ship"} {
+		name := name
+		s.Step(name, func(ctx context.Context, i *Instance) error {
+			if err := c.write(ctx, "do "+name); err != nil {
+				return err
+			}
+			if err := fail[name]; err != nil {
+				return err
+			}
+			i.Data = append(append([]byte{}, i.Data...), name[0])
+			return nil
+		}, func(ctx context.Context, i *Instance) error {
+			if err := fail["undo "+name]; err != nil {
+				return err
+			}
+			return c.write(ctx, "undo "+name)
+		})
+	}
+	return s
+}
+
+func TestExecute(t *testing.T) {
+	for _, c := range storeCases(t, "execute") {
+		t.Run(c.name, func(t *testing.T) {
+			o := NewOrchestrator(uow.NewManager(c.factory), c.store, nil)
+			o.Register(orderSaga(c, nil))
+			i, err := o.Execute(context.Background(), "order", []byte("-"))
+			assert.NoError(t, err)
+			assert.Equal(t, StatusCompleted, i.Status)
+			assert.Equal(t, 3, i.Step)
+			assert.Equal(t, []string{"do reserve", "do charge", "do ship"}, c.log(t))
+
+			stored, err := o.Get(context.Background(), i.Id)
+			assert.NoError(t, err)
+			assert.Equal(t, StatusCompleted, stored.Status)
+			assert.Equal(t, []byte("-rcs"), stored.Data)
+			assert.Empty(t, stored.LeaseOwner)
+
+			_, err = o.Execute(context.Background(), "unknown", nil)
+			assert.ErrorIs(t, err, ErrUnknownSaga)
+			_, err = o.Get(context.Background(), "unknown")
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
+
+func TestCompensate(t *testing.T) {
+	for _, c := range storeCases(t, "compensate") {
+		t.Run(c.name, func(t *testing.T) {
+			fail := errors.New("out of stock")
+			o := NewOrchestrator(uow.NewManager(c.factory), c.store, nil)
+			o.Register(orderSaga(c, map[string]error{"ship": fail}))
+			i, err := o.Execute(context.Background(), "order", nil)
+			assert.ErrorIs(t, err, ErrCompensated)
+			assert.ErrorIs(t, err, fail)
+			assert.Equal(t, StatusCompensated, i.Status)
+			assert.Contains(t, i.LastError, "ship")
+			//failed step is rolled back, completed steps are compensated in reverse order
+			assert.Equal(t, []string{"do reserve", "do charge", "undo charge", "undo reserve"}, c.log(t))
+		})
+	}
+}
+
+func TestResume(t *testing.T) {
+	for _, c := range storeCases(t, "resume") {
+		t.Run(c.name, func(t *testing.T) {
+			mgr := uow.NewManager(c.factory)
+			undoFail := errors.New("payment service unavailable")
+			fail := map[string]error{"ship": errors.New("out of stock"), "undo charge": undoFail}
+			o := NewOrchestrator(mgr, c.store, nil, WithLeaseTTL(time.Millisecond))
+			o.Register(orderSaga(c, fail))
+
+			//compensation fails, the instance is left compensating
+			i, err := o.Execute(context.Background(), "order", nil)
+			assert.ErrorIs(t, err, undoFail)
+			assert.Equal(t, StatusCompensating, i.Status)
+			assert.Equal(t, 2, i.Step)
+
+			//created with a rolled back unit of work
+			err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				if _, err := o.Create(ctx, "order", nil); err != nil {
+					return err
+				}
+				return errors.New("fake error")
+			})
+			assert.Error(t, err)
+
+			//resumed by another orchestrator after restart
+			delete(fail, "undo charge")
+			delete(fail, "ship")
+			time.Sleep(5 * time.Millisecond)
+			restarted := NewOrchestrator(mgr, c.store, nil)
+			restarted.Register(orderSaga(c, fail))
+			var created *Instance
+			err = mgr.WithNew(context.Background(), func(ctx context.Context) error {
+				created, err = restarted.Create(ctx, "order", nil)
+				return err
+			})
+			assert.NoError(t, err)
+
+			n, err := restarted.RunOnce(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, 2, n)
+			i, err = restarted.Get(context.Background(), i.Id)
+			assert.NoError(t, err)
+			assert.Equal(t, StatusCompensated, i.Status)
+			created, err = restarted.Get(context.Background(), created.Id)
+			assert.NoError(t, err)
+			assert.Equal(t, StatusCompleted, created.Status)
+			assert.Equal(t, []string{"do reserve", "do charge", "undo charge", "undo reserve", "do reserve", "do charge", "do ship"}, c.log(t))
+
+			n, err = restarted.RunOnce(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, 0, n)
+		})
+	}
+}
+
+func TestLease(t *testing.T) {
+	for _, c := range storeCases(t, "lease") {
+		t.Run(c.name, func(t *testing.T) {
+			i := newInstance("order", nil)
+			assert.NoError(t, c.store.Insert(context.Background(), nil, i))
+			leased, err := c.store.Lease(context.Background(), "a", 10, time.Minute)
+			assert.NoError(t, err)
+			assert.Len(t, leased, 1)
+			assert.Equal(t, "a", leased[0].LeaseOwner)
+			assert.Equal(t, int64(2), leased[0].Version)
+
+			//invisible to other owners
+			leased, err = c.store.Lease(context.Background(), "b", 10, time.Minute)
+			assert.NoError(t, err)
+			assert.Empty(t, leased)
+
+			//stale version
+			i.Step = 1
+			assert.ErrorIs(t, c.store.Update(context.Background(), nil, i, 1), ErrConcurrency)
+		})
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	c := storeCases(t, "start")[0]
+	mgr := uow.NewManager(c.factory)
+	o := NewOrchestrator(mgr, c.store, nil, WithInterval(time.Millisecond))
+	o.Register(orderSaga(c, nil))
+	go o.Start(context.Background())
+
+	var i *Instance
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) (err error) {
+		i, err = o.Create(ctx, "order", nil)
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		stored, err := o.Get(context.Background(), i.Id)
+		return err == nil && stored.Status == StatusCompleted
+	}, time.Second, 5*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, o.Stop(ctx))
+}
+
+func TestRunOnceLeasesEachInstance(t *testing.T) {
+	c := storeCases(t, "lease_each")[0]
+	mgr := uow.NewManager(c.factory)
+	var expired int
+	s := New("slow").Step("wait", func(ctx context.Context, i *Instance) error {
+		if time.Now().After(i.LeaseUntil) {
+			expired++
+		}
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}, nil)
+	o := NewOrchestrator(mgr, c.store, nil, WithLeaseTTL(50*time.Millisecond))
+	o.Register(s)
+	for k := 0; k < 4; k++ {
+		_, err := o.Create(context.Background(), "slow", nil)
+		assert.NoError(t, err)
+	}
+	n, err := o.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	//no instance starts with an expired lease
+	assert.Equal(t, 0, expired)
+}
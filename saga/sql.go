@@ -0,0 +1,157 @@
+package saga
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jace996/uow"
+	usql "github.com/jace996/uow/sql"
+	"time"
+)
+
+// SqlStore stores saga instances with database/sql. Tables should be created in advance, e.g. in sqlite
+//
+//	CREATE TABLE sagas (
+//		id VARCHAR(64) PRIMARY KEY,
+//		name VARCHAR(255) NOT NULL,
+//		status VARCHAR(32) NOT NULL,
+//		step INTEGER NOT NULL,
+//		data BLOB,
+//		last_error TEXT,
+//		version INTEGER NOT NULL,
+//		lease_owner VARCHAR(64),
+//		lease_until TIMESTAMP,
+//		created_at TIMESTAMP NOT NULL,
+//		updated_at TIMESTAMP NOT NULL
+//	);
+type SqlStore struct {
+	db          *sql.DB
+	placeholder usql.Placeholder
+	opt         *storeOptions
+}
+
+var _ Store = (*SqlStore)(nil)
+
+func NewSqlStore(db *sql.DB, placeholder usql.Placeholder, opts ...StoreOption) *SqlStore {
+	return &SqlStore{db: db, placeholder: placeholder, opt: newStoreOptions(opts...)}
+}
+
+func (s *SqlStore) resolve(tx uow.Txn) (usql.Executor, error) {
+	if tx == nil {
+		return s.db, nil
+	}
+	t, ok := tx.(*usql.TransactionDb)
+	if !ok {
+		return nil, ErrUnsupportedTxn
+	}
+	return t, nil
+}
+
+func nullLease(i *Instance) (sql.NullString, sql.NullTime) {
+	return sql.NullString{String: i.LeaseOwner, Valid: len(i.LeaseOwner) > 0},
+		sql.NullTime{Time: i.LeaseUntil, Valid: !i.LeaseUntil.IsZero()}
+}
+
+func (s *SqlStore) Insert(ctx context.Context, tx uow.Txn, i *Instance) error {
+	db, err := s.resolve(tx)
+	if err != nil {
+		return err
+	}
+	owner, until := nullLease(i)
+	_, err = db.ExecContext(ctx, s.placeholder.Rebind("INSERT INTO "+s.opt.table+
+		" (id, name, status, step, data, last_error, version, lease_owner, lease_until, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		i.Id, i.Name, string(i.Status), i.Step, i.Data, i.LastError, i.Version, owner, until, i.CreatedAt, i.UpdatedAt)
+	return err
+}
+
+func (s *SqlStore) Update(ctx context.Context, tx uow.Txn, i *Instance, expected int64) error {
+	db, err := s.resolve(tx)
+	if err != nil {
+		return err
+	}
+	owner, until := nullLease(i)
+	res, err := db.ExecContext(ctx, s.placeholder.Rebind("UPDATE "+s.opt.table+
+		" SET status = ?, step = ?, data = ?, last_error = ?, version = ?, lease_owner = ?, lease_until = ?, updated_at = ? WHERE id = ? AND version = ?"),
+		string(i.Status), i.Step, i.Data, i.LastError, i.Version, owner, until, i.UpdatedAt, i.Id, expected)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n != 1 {
+		return ErrConcurrency
+	}
+	return nil
+}
+
+const columns = "id, name, status, step, data, last_error, version, lease_owner, lease_until, created_at, updated_at"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanInstance(row scanner) (*Instance, error) {
+	i := &Instance{}
+	var status string
+	var lastErr, owner sql.NullString
+	var until sql.NullTime
+	if err := row.Scan(&i.Id, &i.Name, &status, &i.Step, &i.Data, &lastErr, &i.Version, &owner, &until, &i.CreatedAt, &i.UpdatedAt); err != nil {
+		return nil, err
+	}
+	i.Status, i.LastError, i.LeaseOwner, i.LeaseUntil = Status(status), lastErr.String, owner.String, until.Time
+	return i, nil
+}
+
+func (s *SqlStore) Get(ctx context.Context, tx uow.Txn, id string) (*Instance, error) {
+	db, err := s.resolve(tx)
+	if err != nil {
+		return nil, err
+	}
+	i, err := scanInstance(db.QueryRowContext(ctx, s.placeholder.Rebind("SELECT "+columns+" FROM "+s.opt.table+" WHERE id = ?"), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return i, err
+}
+
+func (s *SqlStore) Lease(ctx context.Context, owner string, limit int, ttl time.Duration) ([]*Instance, error) {
+	now := time.Now().UTC()
+	rows, err := s.db.QueryContext(ctx, s.placeholder.Rebind("SELECT "+columns+" FROM "+s.opt.table+
+		" WHERE status IN (?, ?) AND (lease_until IS NULL OR lease_until < ?) ORDER BY created_at LIMIT ?"),
+		string(StatusRunning), string(StatusCompensating), now, limit)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []*Instance
+	for rows.Next() {
+		i, err := scanInstance(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		candidates = append(candidates, i)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	until := now.Add(ttl)
+	claim := s.placeholder.Rebind("UPDATE " + s.opt.table + " SET lease_owner = ?, lease_until = ?, version = ? WHERE id = ? AND version = ?")
+	var ret []*Instance
+	for _, i := range candidates {
+		//claim the instance if no other owner claimed or updated it
+		res, err := s.db.ExecContext(ctx, claim, owner, until, i.Version+1, i.Id, i.Version)
+		if err != nil {
+			return ret, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return ret, err
+		} else if n != 1 {
+			continue
+		}
+		i.Version, i.LeaseOwner, i.LeaseUntil = i.Version+1, owner, until
+		ret = append(ret, i)
+	}
+	return ret, nil
+}
@@ -0,0 +1,111 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jace996/uow"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+var (
+	client *sql.DB
+)
+
+func TestMain(m *testing.M) {
+	var err error
+	client, err = sql.Open("sqlite3", "file:sql_test.DB?cache=shared&mode=memory")
+	if err != nil {
+		panic(err)
+	}
+	client.SetMaxOpenConns(1)
+	if _, err = client.Exec("CREATE TABLE posts (id INTEGER PRIMARY KEY)"); err != nil {
+		panic(err)
+	}
+	exitCode := m.Run()
+	os.Exit(exitCode)
+}
+
+func clientResolver(ctx context.Context) *TransactionDb {
+	u, ok := uow.FromCurrentUow(ctx)
+	if !ok {
+		panic("can not find uow")
+	}
+	db, err := u.GetTxDb(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return db.(*TransactionDb)
+}
+
+func createPost(ctx context.Context, id int) error {
+	_, err := clientResolver(ctx).ExecContext(ctx, "INSERT INTO posts (id) VALUES (?)", id)
+	return err
+}
+
+func exists(t *testing.T, id int) bool {
+	var n int
+	err := client.QueryRow("SELECT COUNT(*) FROM posts WHERE id = ?", id).Scan(&n)
+	assert.NoError(t, err)
+	return n > 0
+}
+
+func newManager(opts ...uow.Option) uow.Manager {
+	return uow.NewManager(func(ctx context.Context, keys ...string) (uow.TransactionalDb, error) {
+		return NewTransactionDb(client), nil
+	}, opts...)
+}
+
+func TestCommit(t *testing.T) {
+	err := newManager().WithNew(context.Background(), func(ctx context.Context) error {
+		return createPost(ctx, 1001)
+	})
+	assert.NoError(t, err)
+	assert.True(t, exists(t, 1001))
+}
+
+func TestRollback(t *testing.T) {
+	err := newManager().WithNew(context.Background(), func(ctx context.Context) error {
+		if err := createPost(ctx, 1000); err != nil {
+			return err
+		}
+		//just return fake err to trigger transaction rollback
+		return fmt.Errorf("fake error")
+	})
+	assert.Error(t, err)
+	assert.False(t, exists(t, 1000))
+}
+
+func TestNested(t *testing.T) {
+	mgr := newManager()
+	//level 1
+	err := mgr.WithNew(context.Background(), func(ctx context.Context) error {
+		if err := createPost(ctx, 1002); err != nil {
+			return err
+		}
+		//level 2 rollback to savepoint
+		err := mgr.WithNew(ctx, func(ctx context.Context) error {
+			if err := createPost(ctx, 1003); err != nil {
+				return err
+			}
+			return fmt.Errorf("fake error")
+		})
+		assert.Error(t, err)
+		//level 2 commit
+		return mgr.WithNew(ctx, func(ctx context.Context) error {
+			return createPost(ctx, 1004)
+		})
+	})
+	assert.NoError(t, err)
+	assert.True(t, exists(t, 1002))
+	assert.False(t, exists(t, 1003))
+	assert.True(t, exists(t, 1004))
+}
+
+func TestRebind(t *testing.T) {
+	assert.Equal(t, "SELECT ? , ?", Question.Rebind("SELECT ? , ?"))
+	assert.Equal(t, "SELECT $1 , $2", Dollar.Rebind("SELECT ? , ?"))
+}
@@ -0,0 +1,138 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jace996/uow"
+	"strconv"
+	"strings"
+)
+
+// Executor is implemented by both *sql.DB and *sql.Tx
+type Executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type (
+	RollbackFunc  func() error
+	CommitFunc    func() error
+	TransactionDb struct {
+		Executor
+
+		db           *sql.DB
+		tx           *sql.Tx
+		commitFunc   CommitFunc
+		rollbackFunc RollbackFunc
+	}
+)
+
+var (
+	_ uow.TransactionalDb = (*TransactionDb)(nil)
+	_ uow.Txn             = (*TransactionDb)(nil)
+)
+
+// NewTransactionDb create a wrapper of *sql.DB which implements uow.TransactionalDb
+func NewTransactionDb(db *sql.DB) *TransactionDb {
+	return &TransactionDb{
+		Executor: db,
+		db:       db,
+	}
+}
+
+// DB returns the underlying *sql.DB
+func (t *TransactionDb) DB() *sql.DB {
+	return t.db
+}
+
+// Tx returns the underlying *sql.Tx. nil if not in transaction
+func (t *TransactionDb) Tx() *sql.Tx {
+	return t.tx
+}
+
+func (t *TransactionDb) Commit() error {
+	if t.commitFunc != nil {
+		return t.commitFunc()
+	}
+	if t.tx == nil {
+		return sql.ErrTxDone
+	}
+	return t.tx.Commit()
+}
+
+func (t *TransactionDb) Rollback() error {
+	if t.rollbackFunc != nil {
+		return t.rollbackFunc()
+	}
+	if t.tx == nil {
+		return sql.ErrTxDone
+	}
+	return t.tx.Rollback()
+}
+
+func (t *TransactionDb) Begin(opt ...*sql.TxOptions) (uow.Txn, error) {
+	if t.tx != nil {
+		// nested transaction
+		//create save point
+		sp := fmt.Sprintf("sp%p", t)
+		if _, err := t.tx.Exec("SAVEPOINT " + sp); err != nil {
+			return nil, err
+		}
+		return &TransactionDb{
+			Executor: t.tx,
+			db:       t.db,
+			tx:       t.tx,
+			//nested level do not need to commit
+			commitFunc: func() error {
+				return nil
+			},
+			rollbackFunc: func() error {
+				_, err := t.tx.Exec("ROLLBACK TO SAVEPOINT " + sp)
+				return err
+			},
+		}, nil
+	}
+	var txOpt *sql.TxOptions
+	if len(opt) > 0 {
+		txOpt = opt[0]
+	}
+	tx, err := t.db.BeginTx(context.Background(), txOpt)
+	if err != nil {
+		return nil, err
+	}
+	return &TransactionDb{
+		Executor: tx,
+		db:       t.db,
+		tx:       tx,
+	}, nil
+}
+
+// Placeholder is the bind variable style of a database driver
+type Placeholder int
+
+const (
+	// Question uses ? (mysql, sqlite)
+	Question Placeholder = iota
+	// Dollar uses $1, $2 ... (postgres)
+	Dollar
+)
+
+// Rebind replaces ? in query with the bind variable style of p
+func (p Placeholder) Rebind(query string) string {
+	if p != Dollar {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
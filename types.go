@@ -17,3 +17,9 @@ type Txn interface {
 
 // DbFactory resolve transactional db by database keys
 type DbFactory func(ctx context.Context, keys ...string) (TransactionalDb, error)
+
+// LastCommitter is implemented by Txn which should be committed after all other transactions of a unit of work,
+// e.g. buffered events which should go out only if data is committed
+type LastCommitter interface {
+	CommitLast() bool
+}
@@ -20,10 +20,12 @@ type UnitOfWork struct {
 	factory       DbFactory
 	disableNested bool
 	// db can be any kind of client
-	db        *orderedmap.OrderedMap[string, Txn]
-	mtx       sync.Mutex
-	opt       []*sql.TxOptions
-	formatter KeyFormatter
+	db           *orderedmap.OrderedMap[string, Txn]
+	mtx          sync.Mutex
+	opt          []*sql.TxOptions
+	formatter    KeyFormatter
+	beforeCommit []func() error
+	committed    []func()
 }
 
 func newUnitOfWork(id string, disableNested bool, parent *UnitOfWork, factory DbFactory, formatter KeyFormatter, opt ...*sql.TxOptions) *UnitOfWork {
@@ -39,16 +41,57 @@ func newUnitOfWork(id string, disableNested bool, parent *UnitOfWork, factory Db
 }
 
 func (u *UnitOfWork) Commit() error {
-	for el := u.db.Back(); el != nil; el = el.Prev() {
-		err := el.Value.Commit()
-		if err != nil {
-			return err
+	//hooks may register more hooks
+	for {
+		u.mtx.Lock()
+		hooks := u.beforeCommit
+		u.beforeCommit = nil
+		u.mtx.Unlock()
+		if len(hooks) == 0 {
+			break
+		}
+		for _, fn := range hooks {
+			if err := fn(); err != nil {
+				return err
+			}
 		}
 	}
+	//transactions are committed in reverse order, LastCommitter after all others
+	for _, last := range []bool{false, true} {
+		for el := u.db.Back(); el != nil; el = el.Prev() {
+			if commitLast(el.Value) != last {
+				continue
+			}
+			if err := el.Value.Commit(); err != nil {
+				return err
+			}
+		}
+	}
+	u.mtx.Lock()
+	hooks := u.committed
+	u.committed = nil
+	u.mtx.Unlock()
+	if u.parent != nil {
+		//nested unit of work is not really committed until root commits
+		u.parent.OnCommitted(hooks...)
+		return nil
+	}
+	for _, fn := range hooks {
+		fn()
+	}
 	return nil
 }
 
+func commitLast(tx Txn) bool {
+	l, ok := tx.(LastCommitter)
+	return ok && l.CommitLast()
+}
+
 func (u *UnitOfWork) Rollback() error {
+	u.mtx.Lock()
+	u.beforeCommit = nil
+	u.committed = nil
+	u.mtx.Unlock()
 	var errs []string
 	for el := u.db.Back(); el != nil; el = el.Prev() {
 		err := el.Value.Rollback()
@@ -67,6 +110,27 @@ func (u *UnitOfWork) GetId() string {
 	return u.id
 }
 
+// Parent returns the outer unit of work. nil for root
+func (u *UnitOfWork) Parent() *UnitOfWork {
+	return u.parent
+}
+
+// OnBeforeCommit register functions called in order before this unit of work commits its transactions.
+// Functions registered while running them are also called. If any returns error, nothing is committed
+func (u *UnitOfWork) OnBeforeCommit(fn ...func() error) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	u.beforeCommit = append(u.beforeCommit, fn...)
+}
+
+// OnCommitted register functions called after the root unit of work commits.
+// Functions registered in a nested unit of work are discarded if any unit of work in the chain rolls back
+func (u *UnitOfWork) OnCommitted(fn ...func()) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	u.committed = append(u.committed, fn...)
+}
+
 func (u *UnitOfWork) GetTxDb(ctx context.Context, keys ...string) (tx Txn, err error) {
 	u.mtx.Lock()
 	defer u.mtx.Unlock()